@@ -4,47 +4,160 @@ import (
 	"glamapp/src/config"
 	"glamapp/src/database"
 	"glamapp/src/handlers"
+	"glamapp/src/mailer"
+	"glamapp/src/models"
+	"glamapp/src/oidc"
+	"glamapp/src/storage"
+	"glamapp/src/tokens"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/rs/zerolog"
 )
 
-func RegisterAuthRoutes(app *fiber.App, db *database.MongoDB, logger zerolog.Logger) {
+func RegisterAuthRoutes(app *fiber.App, db *database.MongoDB, keys *tokens.KeySet, providers map[string]*oidc.Provider, logger zerolog.Logger) {
 	conf := config.ReadConfig()
-	authHandler := handlers.NewAuthHandler(db, logger, conf.JWTSecret)
+	accountLockout := database.LockoutPolicy{
+		MaxFailures: conf.LoginMaxFailures,
+		BaseDelay:   conf.LoginLockoutBase,
+		MaxDelay:    conf.LoginLockoutMax,
+	}
+	ipLockout := database.LockoutPolicy{
+		MaxFailures: conf.LoginIPMaxFailures,
+		BaseDelay:   conf.LoginLockoutBase,
+		MaxDelay:    conf.LoginLockoutMax,
+	}
+	authHandler := handlers.NewAuthHandler(db, logger, keys, conf.AccessTokenTTL, conf.RefreshTokenTTL, accountLockout, ipLockout)
 
 	auth := app.Group("/auth")
 	auth.Post("/register", authHandler.Register)
 	auth.Post("/login", authHandler.Login)
+	auth.Post("/login/mfa", authHandler.LoginMFA)
+	auth.Post("/refresh", authHandler.Refresh)
+	auth.Post("/logout", JWTMiddleware(db, keys, logger), RequireSession(), authHandler.Logout)
+	auth.Post("/logout-all", JWTMiddleware(db, keys, logger), RequireSession(), authHandler.LogoutAll)
+
+	sender, err := mailer.NewSender(conf.MailSender, conf.MailDir, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to create mail sender")
+	}
+	passwordResetHandler := handlers.NewPasswordResetHandler(db, logger, sender, conf.PasswordResetTTL, conf.PasswordResetURL)
+
+	auth.Post("/password/forgot", passwordResetHandler.ForgotPassword)
+	auth.Post("/password/reset", passwordResetHandler.ResetPassword)
+
+	emailChangeHandler := handlers.NewEmailChangeHandler(db, logger, sender, conf.EmailChangeTTL, conf.EmailChangeURL)
+	auth.Post("/email/confirm", emailChangeHandler.ConfirmEmail)
+
+	oidcHandler := handlers.NewOIDCHandler(authHandler, providers)
+	auth.Get("/oidc/:provider/login", oidcHandler.Login)
+	auth.Get("/oidc/:provider/link", oidcHandler.StartLink)
+	auth.Get("/oidc/:provider/callback", oidcHandler.Callback)
 }
 
-func RegisterProfileRoutes(router fiber.Router, db *database.MongoDB, logger zerolog.Logger) {
-	userHandler := handlers.NewUserHandler(db, logger)
+func RegisterWellKnownRoutes(app *fiber.App, keys *tokens.KeySet) {
+	wellKnown := app.Group("/.well-known")
+	wellKnown.Get("/jwks.json", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+		return c.JSON(keys.JWKS())
+	})
+}
+
+func RegisterProfileRoutes(router fiber.Router, db *database.MongoDB, blobs storage.BlobStore, logger zerolog.Logger) {
+	conf := config.ReadConfig()
+	userHandler := handlers.NewUserHandler(db, blobs, logger, conf.DeactivationGracePeriod)
+	twoFactorHandler := handlers.NewTwoFactorHandler(db, logger)
+	followHandler := handlers.NewFollowHandler(db, logger)
+	restrictionHandler := handlers.NewRestrictionHandler(db, logger)
+	exportHandler := handlers.NewExportHandler(db, blobs, logger, conf.ExportInterval)
+
+	sender, err := mailer.NewSender(conf.MailSender, conf.MailDir, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to create mail sender")
+	}
+	emailChangeHandler := handlers.NewEmailChangeHandler(db, logger, sender, conf.EmailChangeTTL, conf.EmailChangeURL)
 
 	users := router.Group("/users")
-	users.Get("/", userHandler.GetUsers)
-	users.Get("/me", userHandler.Me)
-	users.Get("/:id", userHandler.GetUser)
-	users.Patch("/:id", userHandler.UpdateUser)
-	users.Delete("/:id", userHandler.DeleteUser)
-	users.Get("/:id/avatar", userHandler.GetAvatar)
+	users.Get("/", RequireScope(models.ScopeUsersRead), userHandler.GetUsers)
+	users.Get("/search", RequireScope(models.ScopeUsersRead), userHandler.SearchUsers)
+	users.Get("/me", RequireScope(models.ScopeUsersRead), userHandler.Me)
+	users.Patch("/me", RequireScope(models.ScopeUsersWrite), userHandler.UpdateMe)
+	users.Post("/me/password", RequireSession(), userHandler.ChangePassword)
+	users.Post("/me/email", RequireSession(), emailChangeHandler.ChangeEmail)
+	users.Post("/me/2fa/setup", RequireSession(), twoFactorHandler.Setup)
+	users.Post("/me/2fa/confirm", RequireSession(), twoFactorHandler.Confirm)
+	users.Post("/me/2fa/disable", RequireSession(), twoFactorHandler.Disable)
+	users.Post("/me/export", RequireSession(), exportHandler.CreateExport)
+	users.Get("/me/export/:id", RequireSession(), exportHandler.GetExport)
+	users.Get("/me/follow-requests", RequireScope(models.ScopeUsersRead), followHandler.GetFollowRequests)
+	users.Post("/me/follow-requests/:id/approve", RequireScope(models.ScopeUsersWrite), followHandler.ApproveFollowRequest)
+	users.Post("/me/follow-requests/:id/reject", RequireScope(models.ScopeUsersWrite), followHandler.RejectFollowRequest)
+	users.Get("/:id", RequireScope(models.ScopeUsersRead), userHandler.GetUser)
+	users.Patch("/:id", RequireScope(models.ScopeUsersWrite), userHandler.UpdateUser)
+	users.Delete("/:id", RequireSession(), userHandler.DeleteUser)
+	users.Get("/:id/deletion", RequireSession(), userHandler.GetDeletion)
+	users.Get("/:id/avatar", RequireScope(models.ScopeUsersRead), userHandler.GetAvatar)
+	users.Put("/:id/role", RequireSession(), RequireRole(models.RoleAdmin), userHandler.SetRole)
+	users.Post("/:id/follow", RequireScope(models.ScopeUsersWrite), followHandler.Follow)
+	users.Delete("/:id/follow", RequireScope(models.ScopeUsersWrite), followHandler.Unfollow)
+	users.Get("/:id/followers", RequireScope(models.ScopeUsersRead), followHandler.GetFollowers)
+	users.Get("/:id/following", RequireScope(models.ScopeUsersRead), followHandler.GetFollowing)
+	users.Post("/:id/block", RequireScope(models.ScopeUsersWrite), restrictionHandler.Block)
+	users.Delete("/:id/block", RequireScope(models.ScopeUsersWrite), restrictionHandler.Unblock)
+	users.Post("/:id/mute", RequireScope(models.ScopeUsersWrite), restrictionHandler.Mute)
+	users.Delete("/:id/mute", RequireScope(models.ScopeUsersWrite), restrictionHandler.Unmute)
 }
 
 func RegisterPostRoutes(router fiber.Router, db *database.MongoDB, logger zerolog.Logger) {
-	postHandler := handlers.NewPostHandler(db, logger)
+	conf := config.ReadConfig()
+	postHandler := handlers.NewPostHandler(db, logger, conf.PostEditWindow, conf.CommentMaxDepth)
 
 	posts := router.Group("/posts")
-	posts.Post("/", postHandler.CreatePost)
-	posts.Get("/", postHandler.GetPosts)
-	posts.Get("/:id", postHandler.GetPost)
-	posts.Post("/:id/like", postHandler.LikePost)
-	posts.Delete("/:id", postHandler.DeletePost)
-	posts.Get("/profile/:profileId", postHandler.GetPostsByProfile)
+	posts.Post("/", RequireScope(models.ScopePostsWrite), postHandler.CreatePost)
+	posts.Get("/", RequireScope(models.ScopePostsRead), postHandler.GetPosts)
+	posts.Get("/:id", RequireScope(models.ScopePostsRead), postHandler.GetPost)
+	posts.Patch("/:id", RequireScope(models.ScopePostsWrite), postHandler.EditPost)
+	posts.Get("/:id/revisions", RequireScope(models.ScopePostsRead), postHandler.GetPostRevisions)
+	posts.Post("/:id/like", RequireScope(models.ScopePostsWrite), postHandler.LikePost)
+	posts.Delete("/:id/like", RequireScope(models.ScopePostsWrite), postHandler.UnlikePost)
+	posts.Get("/:id/likes", RequireScope(models.ScopePostsRead), postHandler.GetPostLikes)
+	posts.Post("/:id/comments", RequireScope(models.ScopePostsWrite), postHandler.CreateComment)
+	posts.Get("/:id/comments", RequireScope(models.ScopePostsRead), postHandler.GetComments)
+	posts.Delete("/:id/comments/:commentId", RequireScope(models.ScopePostsWrite), postHandler.DeleteComment)
+	posts.Post("/:id/comments/:commentId/like", RequireScope(models.ScopePostsWrite), postHandler.LikeComment)
+	posts.Delete("/:id/comments/:commentId/like", RequireScope(models.ScopePostsWrite), postHandler.UnlikeComment)
+	posts.Delete("/:id", RequireScope(models.ScopePostsWrite), postHandler.DeletePost)
+	posts.Get("/profile/:profileId", RequireScope(models.ScopePostsRead), postHandler.GetPostsByProfile)
 }
 
 func RegisterNotificationRoutes(router fiber.Router, db *database.MongoDB, logger zerolog.Logger) {
 	notificationHandler := handlers.NewNotificationHandler(db, logger)
 
 	notifications := router.Group("/notifications")
-	notifications.Get("/", notificationHandler.ReadAllNotifications)
+	notifications.Get("/", RequireScope(models.ScopeNotificationsRead), notificationHandler.ReadAllNotifications)
+}
+
+func RegisterSessionRoutes(router fiber.Router, db *database.MongoDB, logger zerolog.Logger) {
+	sessionHandler := handlers.NewSessionHandler(db, logger)
+
+	sessions := router.Group("/sessions", RequireSession())
+	sessions.Get("/", sessionHandler.GetSessions)
+	sessions.Delete("/:id", sessionHandler.DeleteSession)
+}
+
+func RegisterIdentityRoutes(router fiber.Router, db *database.MongoDB, providers map[string]*oidc.Provider, logger zerolog.Logger) {
+	identityHandler := handlers.NewIdentityHandler(db, logger, providers)
+
+	identities := router.Group("/users/me/identities", RequireSession())
+	identities.Get("/", identityHandler.GetIdentities)
+	identities.Post("/:provider", identityHandler.LinkIdentity)
+	identities.Delete("/:id", identityHandler.DeleteIdentity)
+}
+
+func RegisterAccessTokenRoutes(router fiber.Router, db *database.MongoDB, logger zerolog.Logger) {
+	accessTokenHandler := handlers.NewAccessTokenHandler(db, logger)
+
+	accessTokens := router.Group("/tokens", RequireSession())
+	accessTokens.Post("/", accessTokenHandler.CreateAccessToken)
+	accessTokens.Get("/", accessTokenHandler.GetAccessTokens)
+	accessTokens.Delete("/:id", accessTokenHandler.DeleteAccessToken)
 }
@@ -1,13 +1,12 @@
 package api
 
 import (
-	"glamapp/src/config"
 	"glamapp/src/database"
 	"glamapp/src/models"
+	"glamapp/src/tokens"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt"
 	"github.com/rs/zerolog"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -20,6 +19,12 @@ func SessionMiddleware(db *database.MongoDB, logger zerolog.Logger) fiber.Handle
 			return c.Next()
 		}
 
+		if session, ok := c.Locals("session").(*models.Session); ok {
+			if err := db.UpdateSessionActivity(session.ID); err != nil {
+				logger.Error().Err(err).Str("session_id", session.ID.Hex()).Msg("Failed to update session activity")
+			}
+		}
+
 		logger.Debug().Str("user_id", user.ID.Hex()).Msg("User found in context, logging history")
 
 		err := db.LogHistory(user.ID)
@@ -33,7 +38,7 @@ func SessionMiddleware(db *database.MongoDB, logger zerolog.Logger) fiber.Handle
 	}
 }
 
-func JWTMiddleware(db *database.MongoDB, logger zerolog.Logger) fiber.Handler {
+func JWTMiddleware(db *database.MongoDB, keys *tokens.KeySet, logger zerolog.Logger) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		authHeader := c.Get("Authorization")
 		if authHeader == "" {
@@ -49,20 +54,34 @@ func JWTMiddleware(db *database.MongoDB, logger zerolog.Logger) fiber.Handler {
 
 		token := strings.TrimPrefix(authHeader, bearerPrefix)
 
-		claims := jwt.MapClaims{}
-		_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-			conf := config.ReadConfig()
-			return []byte(conf.JWTSecret), nil
-		})
+		if strings.HasPrefix(token, tokens.PersonalPrefix) {
+			return authenticateAccessToken(c, db, token, logger)
+		}
+
+		claims, err := keys.Verify(token, tokens.TypeAccess)
+		if err != nil {
+			logger.Error().Err(err).Str("path", c.Path()).Msg("Invalid or expired token")
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+		}
 
+		userID, err := primitive.ObjectIDFromHex(claims.UserID)
 		if err != nil {
-			logger.Error().Err(err).Str("token", token).Msg("Invalid or expired token")
+			logger.Error().Err(err).Str("user_id", claims.UserID).Msg("Invalid user ID in token")
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
 		}
 
-		userID, err := primitive.ObjectIDFromHex(claims["user_id"].(string))
+		sid := claims.SessionID
+		sessionID, err := primitive.ObjectIDFromHex(sid)
 		if err != nil {
-			logger.Error().Err(err).Str("user_id", claims["user_id"].(string)).Msg("Invalid user ID in token")
+			logger.Warn().Str("user_id", userID.Hex()).Msg("Token without a valid session ID")
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+		}
+
+		// Access tokens stay signature-valid until they expire, so the session
+		// lookup is what makes logout and revocation effective immediately.
+		session, err := db.GetSessionByID(sessionID)
+		if err != nil || session.UserID != userID {
+			logger.Warn().Err(err).Str("user_id", userID.Hex()).Str("session_id", sid).Msg("Session revoked or not found")
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
 		}
 
@@ -71,10 +90,51 @@ func JWTMiddleware(db *database.MongoDB, logger zerolog.Logger) fiber.Handler {
 			logger.Error().Err(err).Str("user_id", userID.Hex()).Msg("User not found")
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
 		}
+		if user.Deactivated() {
+			logger.Warn().Str("user_id", userID.Hex()).Msg("Token of deactivated user")
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+		}
 
 		logger.Debug().Str("user_id", userID.Hex()).Msg("User authenticated successfully")
 		c.Locals("user", user)
+		c.Locals("session", session)
 
 		return c.Next()
 	}
 }
+
+// authenticateAccessToken authenticates a request made with a personal
+// access token. The token's scopes are stored in the context for
+// RequireScope; there is no session.
+func authenticateAccessToken(c *fiber.Ctx, db *database.MongoDB, token string, logger zerolog.Logger) error {
+	accessToken, err := db.GetAccessTokenByHash(tokens.Hash(token))
+	if err != nil {
+		logger.Warn().Err(err).Str("path", c.Path()).Msg("Unknown access token")
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	if accessToken.Expired() {
+		logger.Warn().Str("token_id", accessToken.ID.Hex()).Msg("Expired access token")
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	user, err := db.GetUserByID(accessToken.UserID)
+	if err != nil {
+		logger.Error().Err(err).Str("user_id", accessToken.UserID.Hex()).Msg("User not found")
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	if user.Deactivated() {
+		logger.Warn().Str("user_id", user.ID.Hex()).Msg("Access token of deactivated user")
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	if err := db.TouchAccessToken(accessToken.ID); err != nil {
+		logger.Error().Err(err).Str("token_id", accessToken.ID.Hex()).Msg("Failed to update access token usage")
+	}
+
+	logger.Debug().Str("user_id", user.ID.Hex()).Str("token_id", accessToken.ID.Hex()).Msg("User authenticated with access token")
+	c.Locals("user", user)
+	c.Locals("access_token", accessToken)
+
+	return c.Next()
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"glamapp/src/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireRole only lets users holding role (or a more privileged one) reach
+// the route. It must be registered after JWTMiddleware.
+func RequireRole(role models.Role) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Locals("user").(*models.User)
+		if !ok || !user.HasRole(role) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Forbidden"})
+		}
+
+		return c.Next()
+	}
+}
+
+// RequireScope restricts personal access tokens to routes covered by their
+// scopes. Requests authenticated with a login session are not restricted.
+func RequireScope(scope models.Scope) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if token, ok := c.Locals("access_token").(*models.AccessToken); ok && !token.HasScope(scope) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Access token is missing the " + string(scope) + " scope"})
+		}
+
+		return c.Next()
+	}
+}
+
+// RequireSession keeps personal access tokens away from account management
+// routes such as password changes, sessions and the tokens themselves.
+func RequireSession() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, ok := c.Locals("session").(*models.Session); !ok {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "This route requires a login session"})
+		}
+
+		return c.Next()
+	}
+}
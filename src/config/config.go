@@ -4,6 +4,7 @@ import (
 	"os"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
@@ -13,9 +14,62 @@ type Config struct {
 	DatabaseURI string `mapstructure:"mongodb_uri"`
 	Database    string `mapstructure:"mongo_database"`
 
-	AppPort   string `mapstructure:"app_port"`
-	AppHost   string `mapstructure:"app_host"`
-	JWTSecret string `mapstructure:"jwt_secret"`
+	AppPort string `mapstructure:"app_port"`
+	AppHost string `mapstructure:"app_host"`
+
+	JWTKeysDir    string `mapstructure:"jwt_keys_dir"`
+	JWTSigningKID string `mapstructure:"jwt_signing_kid"`
+	JWTIssuer     string `mapstructure:"jwt_issuer"`
+	// JWTEphemeralKeys allows starting without jwt_keys_dir by generating a
+	// new signing key on every start. Only meant for development.
+	JWTEphemeralKeys bool `mapstructure:"jwt_ephemeral_keys"`
+
+	AccessTokenTTL  time.Duration `mapstructure:"access_token_ttl"`
+	RefreshTokenTTL time.Duration `mapstructure:"refresh_token_ttl"`
+
+	LoginMaxFailures   int           `mapstructure:"login_max_failures"`
+	LoginIPMaxFailures int           `mapstructure:"login_ip_max_failures"`
+	LoginLockoutBase   time.Duration `mapstructure:"login_lockout_base"`
+	LoginLockoutMax    time.Duration `mapstructure:"login_lockout_max"`
+
+	BootstrapAdmin string `mapstructure:"bootstrap_admin"`
+
+	OIDCProviders []OIDCProvider `mapstructure:"-"`
+
+	Argon2Memory      uint32 `mapstructure:"argon2_memory"`
+	Argon2Iterations  uint32 `mapstructure:"argon2_iterations"`
+	Argon2Parallelism uint8  `mapstructure:"argon2_parallelism"`
+
+	AvatarMaxBytes int `mapstructure:"avatar_max_bytes"`
+
+	BlobStore string `mapstructure:"blob_store"`
+	BlobDir   string `mapstructure:"blob_dir"`
+
+	ExportTTL      time.Duration `mapstructure:"export_ttl"`
+	ExportInterval time.Duration `mapstructure:"export_interval"`
+
+	DeactivationGracePeriod time.Duration `mapstructure:"deactivation_grace_period"`
+
+	PostEditWindow  time.Duration `mapstructure:"post_edit_window"`
+	CommentMaxDepth int           `mapstructure:"comment_max_depth"`
+
+	MailSender       string        `mapstructure:"mail_sender"`
+	MailDir          string        `mapstructure:"mail_dir"`
+	PasswordResetTTL time.Duration `mapstructure:"password_reset_ttl"`
+	PasswordResetURL string        `mapstructure:"password_reset_url"`
+	EmailChangeTTL   time.Duration `mapstructure:"email_change_ttl"`
+	EmailChangeURL   string        `mapstructure:"email_change_url"`
+}
+
+// OIDCProvider is read from oidc_<name>_* keys for every name listed in
+// oidc_providers, e.g. OIDC_GOOGLE_ISSUER for the "google" provider.
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
 }
 
 var confAT atomic.Value
@@ -25,7 +79,35 @@ func setDefaults() {
 	viper.SetDefault("mongo_database", "glamapp")
 	viper.SetDefault("app_port", "3001")
 	viper.SetDefault("app_host", "localhost")
-	viper.SetDefault("jwt_secret", "")
+	viper.SetDefault("jwt_keys_dir", "")
+	viper.SetDefault("jwt_signing_kid", "")
+	viper.SetDefault("jwt_issuer", "glamapp")
+	viper.SetDefault("jwt_ephemeral_keys", false)
+	viper.SetDefault("access_token_ttl", "15m")
+	viper.SetDefault("refresh_token_ttl", "720h")
+	viper.SetDefault("login_max_failures", 5)
+	viper.SetDefault("login_ip_max_failures", 20)
+	viper.SetDefault("login_lockout_base", "30s")
+	viper.SetDefault("login_lockout_max", "1h")
+	viper.SetDefault("bootstrap_admin", "")
+	viper.SetDefault("oidc_providers", "")
+	viper.SetDefault("argon2_memory", 64*1024)
+	viper.SetDefault("argon2_iterations", 3)
+	viper.SetDefault("argon2_parallelism", 2)
+	viper.SetDefault("avatar_max_bytes", 5<<20)
+	viper.SetDefault("blob_store", "gridfs")
+	viper.SetDefault("blob_dir", "blobs")
+	viper.SetDefault("export_ttl", "168h")
+	viper.SetDefault("export_interval", "24h")
+	viper.SetDefault("deactivation_grace_period", "720h")
+	viper.SetDefault("post_edit_window", "0s")
+	viper.SetDefault("comment_max_depth", 3)
+	viper.SetDefault("mail_sender", "log")
+	viper.SetDefault("mail_dir", "mail")
+	viper.SetDefault("password_reset_ttl", "1h")
+	viper.SetDefault("password_reset_url", "")
+	viper.SetDefault("email_change_ttl", "24h")
+	viper.SetDefault("email_change_url", "")
 }
 
 func ReadConfig() *Config {
@@ -61,10 +143,95 @@ func ReadConfig() *Config {
 		log.Fatal().Msg("app_port is not set")
 	}
 
-	if config.JWTSecret == "" {
-		log.Fatal().Msg("jwt_secret is not set")
+	if config.JWTKeysDir == "" && !config.JWTEphemeralKeys {
+		log.Fatal().Msg("jwt_keys_dir is not set (set jwt_ephemeral_keys for development)")
+	}
+
+	if config.JWTKeysDir != "" && config.JWTSigningKID == "" {
+		log.Fatal().Msg("jwt_signing_kid is not set")
+	}
+
+	if config.AccessTokenTTL <= 0 {
+		log.Fatal().Msg("access_token_ttl must be positive")
+	}
+
+	if config.RefreshTokenTTL <= config.AccessTokenTTL {
+		log.Fatal().Msg("refresh_token_ttl must be longer than access_token_ttl")
+	}
+	if config.LoginMaxFailures <= 0 || config.LoginIPMaxFailures <= 0 {
+		log.Fatal().Msg("login_max_failures and login_ip_max_failures must be positive")
+	}
+
+	if config.LoginLockoutBase <= 0 || config.LoginLockoutMax < config.LoginLockoutBase {
+		log.Fatal().Msg("login_lockout_base must be positive and not longer than login_lockout_max")
+	}
+
+	if config.Argon2Memory < 8*uint32(config.Argon2Parallelism) || config.Argon2Iterations == 0 || config.Argon2Parallelism == 0 {
+		log.Fatal().Msg("argon2_iterations and argon2_parallelism must be positive and argon2_memory at least 8 KiB per thread")
+	}
+
+	if config.AvatarMaxBytes <= 0 {
+		log.Fatal().Msg("avatar_max_bytes must be positive")
+	}
+
+	if config.ExportTTL <= 0 {
+		log.Fatal().Msg("export_ttl must be positive")
+	}
+
+	if config.ExportInterval < 0 {
+		log.Fatal().Msg("export_interval must not be negative")
+	}
+
+	if config.DeactivationGracePeriod <= 0 {
+		log.Fatal().Msg("deactivation_grace_period must be positive")
+	}
+
+	if config.PostEditWindow < 0 {
+		log.Fatal().Msg("post_edit_window must not be negative")
+	}
+
+	if config.CommentMaxDepth < 0 {
+		log.Fatal().Msg("comment_max_depth must not be negative")
+	}
+
+	if config.PasswordResetTTL <= 0 {
+		log.Fatal().Msg("password_reset_ttl must be positive")
 	}
+
+	if config.EmailChangeTTL <= 0 {
+		log.Fatal().Msg("email_change_ttl must be positive")
+	}
+
+	config.OIDCProviders = readOIDCProviders()
+
 	confAT.Store(config)
 
 	return &config
 }
+
+func readOIDCProviders() []OIDCProvider {
+	providers := []OIDCProvider{}
+	for _, name := range strings.Split(viper.GetString("oidc_providers"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		key := func(suffix string) string { return "oidc_" + name + "_" + suffix }
+		provider := OIDCProvider{
+			Name:         name,
+			Issuer:       viper.GetString(key("issuer")),
+			ClientID:     viper.GetString(key("client_id")),
+			ClientSecret: viper.GetString(key("client_secret")),
+			RedirectURL:  viper.GetString(key("redirect_url")),
+			Scopes:       strings.Fields(viper.GetString(key("scopes"))),
+		}
+
+		if provider.Issuer == "" || provider.ClientID == "" || provider.RedirectURL == "" {
+			log.Fatal().Str("provider", name).Msg("OIDC provider needs issuer, client_id and redirect_url")
+		}
+
+		providers = append(providers, provider)
+	}
+	return providers
+}
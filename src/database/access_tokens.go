@@ -0,0 +1,85 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"glamapp/src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (m *MongoDB) CreateAccessToken(token *models.AccessToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	token.ID = primitive.NewObjectID()
+	token.CreatedAt = time.Now()
+
+	if _, err := m.accessTokens.InsertOne(ctx, token); err != nil {
+		m.logger.Error().Err(err).Str("user_id", token.UserID.Hex()).Msg("Failed to create access token")
+		return err
+	}
+
+	return nil
+}
+
+func (m *MongoDB) GetAccessTokenByHash(tokenHash string) (*models.AccessToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var token models.AccessToken
+	if err := m.accessTokens.FindOne(ctx, bson.M{"token_hash": tokenHash}).Decode(&token); err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (m *MongoDB) GetUserAccessTokens(userID primitive.ObjectID) ([]models.AccessToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := m.accessTokens.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		m.logger.Error().Err(err).Str("user_id", userID.Hex()).Msg("Failed to fetch access tokens")
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	tokens := []models.AccessToken{}
+	if err = cursor.All(ctx, &tokens); err != nil {
+		m.logger.Error().Err(err).Str("user_id", userID.Hex()).Msg("Failed to decode access tokens")
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (m *MongoDB) TouchAccessToken(id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := m.accessTokens.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"last_used_at": time.Now()}})
+	return err
+}
+
+func (m *MongoDB) DeleteUserAccessToken(userID, tokenID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := m.accessTokens.DeleteOne(ctx, bson.M{"_id": tokenID, "user_id": userID})
+	if err != nil {
+		m.logger.Error().Err(err).Str("token_id", tokenID.Hex()).Msg("Failed to delete access token")
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
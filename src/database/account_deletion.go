@@ -0,0 +1,168 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"glamapp/src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The methods below are the steps of an account deletion job. Each of them
+// can run again after a partial failure without changing the outcome.
+
+// deletionBatchSize limits how many posts are removed per round trip.
+const deletionBatchSize = 200
+
+// LockUserForDeletion removes every way to sign in and revokes all sessions
+// and tokens, so nothing new is created for the user while their data is
+// being removed.
+func (m *MongoDB) LockUserForDeletion(ctx context.Context, userID primitive.ObjectID) error {
+	update := bson.M{
+		"$set":   bson.M{"password_hash": "", "totp_enabled": false, "updated_at": time.Now()},
+		"$unset": bson.M{"totp_secret": "", "recovery_codes": ""},
+	}
+	if _, err := m.users.UpdateOne(ctx, bson.M{"_id": userID}, update); err != nil {
+		return fmt.Errorf("failed to lock user: %w", err)
+	}
+
+	for _, collection := range []*mongo.Collection{m.sessions, m.accessTokens, m.identities, m.resets, m.emailChanges, m.oidcStates} {
+		if _, err := collection.DeleteMany(ctx, bson.M{"user_id": userID}); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", collection.Name(), err)
+		}
+	}
+	return nil
+}
+
+// RemoveUserLikes takes the user's likes back from other posts.
+func (m *MongoDB) RemoveUserLikes(ctx context.Context, userID primitive.ObjectID) error {
+	update := bson.M{
+		"$pull": bson.M{"liked_by": userID},
+		"$inc":  bson.M{"likes_count": -1},
+	}
+	if _, err := m.posts.UpdateMany(ctx, bson.M{"liked_by": userID}, update); err != nil {
+		return fmt.Errorf("failed to remove likes: %w", err)
+	}
+	return nil
+}
+
+// DeleteUserComments takes the user's likes back from comments and removes
+// their comments. Comments that have replies stay behind without author and
+// content, see removeComment.
+func (m *MongoDB) DeleteUserComments(ctx context.Context, userID primitive.ObjectID) error {
+	update := bson.M{
+		"$pull": bson.M{"liked_by": userID},
+		"$inc":  bson.M{"likes_count": -1},
+	}
+	if _, err := m.comments.UpdateMany(ctx, bson.M{"liked_by": userID}, update); err != nil {
+		return fmt.Errorf("failed to remove comment likes: %w", err)
+	}
+
+	opts := options.Find().SetLimit(deletionBatchSize)
+	for {
+		cursor, err := m.comments.Find(ctx, bson.M{"author_id": userID, "deleted": bson.M{"$ne": true}}, opts)
+		if err != nil {
+			return fmt.Errorf("failed to fetch comments: %w", err)
+		}
+
+		var comments []models.Comment
+		if err := cursor.All(ctx, &comments); err != nil {
+			return fmt.Errorf("failed to decode comments: %w", err)
+		}
+		if len(comments) == 0 {
+			return nil
+		}
+
+		for i := range comments {
+			if err := m.removeComment(ctx, &comments[i]); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// DeleteUserPosts deletes the user's posts in batches, together with their
+// revisions, comments, the notifications about them and the references in
+// other users' liked_posts.
+func (m *MongoDB) DeleteUserPosts(ctx context.Context, userID primitive.ObjectID) error {
+	if _, err := m.postRevisions.DeleteMany(ctx, bson.M{"author_id": userID}); err != nil {
+		return fmt.Errorf("failed to delete post revisions: %w", err)
+	}
+
+	opts := options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(deletionBatchSize)
+	for {
+		cursor, err := m.posts.Find(ctx, bson.M{"author_id": userID}, opts)
+		if err != nil {
+			return fmt.Errorf("failed to fetch posts: %w", err)
+		}
+
+		var posts []struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.All(ctx, &posts); err != nil {
+			return fmt.Errorf("failed to decode posts: %w", err)
+		}
+		if len(posts) == 0 {
+			return nil
+		}
+
+		ids := make([]primitive.ObjectID, 0, len(posts))
+		hexIDs := make([]string, 0, len(posts))
+		for _, post := range posts {
+			ids = append(ids, post.ID)
+			hexIDs = append(hexIDs, post.ID.Hex())
+		}
+
+		if _, err := m.users.UpdateMany(ctx, bson.M{"liked_posts": bson.M{"$in": hexIDs}}, bson.M{"$pull": bson.M{"liked_posts": bson.M{"$in": hexIDs}}}); err != nil {
+			return fmt.Errorf("failed to remove liked posts: %w", err)
+		}
+		if _, err := m.comments.DeleteMany(ctx, bson.M{"post_id": bson.M{"$in": ids}}); err != nil {
+			return fmt.Errorf("failed to delete post comments: %w", err)
+		}
+		if _, err := m.notifications.DeleteMany(ctx, bson.M{"post_id": bson.M{"$in": ids}}); err != nil {
+			return fmt.Errorf("failed to delete post notifications: %w", err)
+		}
+		if _, err := m.posts.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+			return fmt.Errorf("failed to delete posts: %w", err)
+		}
+	}
+}
+
+// DeleteUserNotifications deletes notifications sent to or caused by the
+// user.
+func (m *MongoDB) DeleteUserNotifications(ctx context.Context, userID primitive.ObjectID) error {
+	filter := bson.M{"$or": bson.A{bson.M{"liked_by": userID}, bson.M{"actor_id": userID}}}
+	if _, err := m.notifications.DeleteMany(ctx, filter); err != nil {
+		return fmt.Errorf("failed to delete notifications: %w", err)
+	}
+	return nil
+}
+
+// DeleteUserRelationships removes follows, blocks and mutes in both
+// directions.
+func (m *MongoDB) DeleteUserRelationships(ctx context.Context, userID primitive.ObjectID) error {
+	if err := m.deleteUserFollows(ctx, userID); err != nil {
+		return err
+	}
+	return m.deleteUserRestrictions(ctx, userID)
+}
+
+func (m *MongoDB) DeleteUserHistory(ctx context.Context, userID primitive.ObjectID) error {
+	if _, err := m.history.DeleteMany(ctx, bson.M{"user_id": userID}); err != nil {
+		return fmt.Errorf("failed to delete history: %w", err)
+	}
+	return nil
+}
+
+// DeleteUserDocument is the last step and removes the user itself.
+func (m *MongoDB) DeleteUserDocument(ctx context.Context, userID primitive.ObjectID) error {
+	if _, err := m.users.DeleteOne(ctx, bson.M{"_id": userID}); err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"glamapp/src/media"
+	"glamapp/src/models"
+	"glamapp/src/storage"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// legacyAvatarUser holds the avatar fields users had before avatars moved
+// to the blob store.
+type legacyAvatarUser struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Avatar      *models.AvatarRef  `bson:"avatar"`
+	AvatarData  []byte             `bson:"avatar_data"`
+	AvatarType  string             `bson:"avatar_type"`
+	AvatarSizes map[string][]byte  `bson:"avatar_sizes"`
+}
+
+// MigrateAvatars moves avatars stored inside user documents to the blob
+// store and removes the inline bytes. Uploads that are not valid images are
+// dropped. It is safe to run on every start and returns the number of users
+// migrated.
+func (m *MongoDB) MigrateAvatars(store storage.BlobStore) (int, error) {
+	ctx := context.Background()
+
+	filter := bson.M{"$or": bson.A{
+		bson.M{"avatar_data": bson.M{"$exists": true}},
+		bson.M{"avatar_sizes": bson.M{"$exists": true}},
+	}}
+	projection := bson.M{"avatar": 1, "avatar_data": 1, "avatar_type": 1, "avatar_sizes": 1}
+	cursor, err := m.users.Find(ctx, filter, options.Find().SetProjection(projection))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		var user legacyAvatarUser
+		if err := cursor.Decode(&user); err != nil {
+			return migrated, err
+		}
+		if err := m.migrateAvatar(store, &user); err != nil {
+			m.logger.Error().Err(err).Str("user_id", user.ID.Hex()).Msg("Failed to migrate avatar")
+			continue
+		}
+		migrated++
+	}
+	return migrated, cursor.Err()
+}
+
+func (m *MongoDB) migrateAvatar(store storage.BlobStore, user *legacyAvatarUser) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	legacyFields := bson.M{"avatar_data": "", "avatar_type": "", "avatar_sizes": ""}
+	update := bson.M{"$unset": legacyFields}
+	var ref *models.AvatarRef
+
+	// Users who uploaded a new avatar since keep it.
+	if user.Avatar == nil {
+		contentType, sizes := user.AvatarType, make(map[int][]byte, len(user.AvatarSizes))
+		for size, data := range user.AvatarSizes {
+			if s, err := strconv.Atoi(size); err == nil {
+				sizes[s] = data
+			}
+		}
+		if len(sizes) == 0 && len(user.AvatarData) > 0 {
+			if avatar, err := media.ProcessAvatar(user.AvatarData); err == nil {
+				contentType, sizes = avatar.ContentType, avatar.Sizes
+			} else {
+				m.logger.Warn().Err(err).Str("user_id", user.ID.Hex()).Msg("Dropping invalid avatar")
+			}
+		}
+
+		if len(sizes) > 0 {
+			var err error
+			ref, err = storage.PutAvatar(ctx, store, user.ID, contentType, sizes)
+			if err != nil {
+				return err
+			}
+			update["$set"] = bson.M{"avatar": ref}
+		}
+	}
+
+	if ref == nil {
+		_, err := m.users.UpdateOne(ctx, bson.M{"_id": user.ID}, update)
+		return err
+	}
+
+	result, err := m.users.UpdateOne(ctx, bson.M{"_id": user.ID, "avatar": bson.M{"$exists": false}}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	// A new avatar was uploaded while migrating, or the user is gone. Drop
+	// the copy just written.
+	if err := storage.DeleteAvatar(ctx, store, ref); err != nil {
+		m.logger.Error().Err(err).Str("user_id", user.ID.Hex()).Msg("Failed to delete migrated avatar")
+	}
+	_, err = m.users.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$unset": legacyFields})
+	return err
+}
@@ -0,0 +1,256 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"glamapp/src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrCommentNotFound is returned when a comment does not exist on the
+	// post or was deleted.
+	ErrCommentNotFound = errors.New("comment not found")
+	// ErrAlreadyLiked is returned when liking a comment twice.
+	ErrAlreadyLiked = errors.New("comment already liked")
+)
+
+// CreateComment stores a comment on post, or a reply to parent if it is not
+// nil, and notifies the authors of the post and the parent comment. Failed
+// notifications are only logged, the comment exists by then.
+func (m *MongoDB) CreateComment(post *models.Post, parent, comment *models.Comment) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The parent is counted first, so it cannot be removed for having no
+	// replies while the reply is being inserted.
+	if parent != nil {
+		filter := bson.M{"_id": parent.ID, "deleted": bson.M{"$ne": true}}
+		result, err := m.comments.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"replies_count": 1}})
+		if err != nil {
+			m.logger.Error().Err(err).Str("comment_id", parent.ID.Hex()).Msg("Failed to update parent comment")
+			return fmt.Errorf("failed to update parent comment: %w", err)
+		}
+		if result.MatchedCount == 0 {
+			return ErrCommentNotFound
+		}
+	}
+
+	if _, err := m.comments.InsertOne(ctx, comment); err != nil {
+		m.logger.Error().Err(err).Str("post_id", post.ID.Hex()).Msg("Failed to insert comment")
+		if parent != nil {
+			if _, err := m.comments.UpdateOne(ctx, bson.M{"_id": parent.ID}, bson.M{"$inc": bson.M{"replies_count": -1}}); err != nil {
+				m.logger.Error().Err(err).Str("comment_id", parent.ID.Hex()).Msg("Failed to restore replies count")
+			}
+		}
+		return fmt.Errorf("failed to insert comment: %w", err)
+	}
+
+	if _, err := m.posts.UpdateOne(ctx, bson.M{"_id": post.ID}, bson.M{"$inc": bson.M{"comments_count": 1}}); err != nil {
+		m.logger.Error().Err(err).Str("post_id", post.ID.Hex()).Msg("Failed to update comments count")
+		return fmt.Errorf("failed to update comments count: %w", err)
+	}
+
+	// The author of a post who is also the author of the parent comment
+	// only hears about the reply.
+	if parent != nil && parent.AuthorID != comment.AuthorID {
+		notification := models.NewNotificationComment(models.NotificationTypeReply, comment, parent.AuthorID, comment.AuthorID)
+		if err := m.notify(ctx, notification); err != nil {
+			m.logger.Error().Err(err).Str("comment_id", comment.ID.Hex()).Msg("Failed to insert notification")
+		}
+	}
+	if post.AuthorID != comment.AuthorID && (parent == nil || parent.AuthorID != post.AuthorID) {
+		notification := models.NewNotificationComment(models.NotificationTypeComment, comment, post.AuthorID, comment.AuthorID)
+		if err := m.notify(ctx, notification); err != nil {
+			m.logger.Error().Err(err).Str("comment_id", comment.ID.Hex()).Msg("Failed to insert notification")
+		}
+	}
+
+	m.logger.Info().Str("post_id", post.ID.Hex()).Str("comment_id", comment.ID.Hex()).Msg("Comment created successfully")
+	return nil
+}
+
+// GetComment returns a comment of postID, including deleted ones that are
+// kept for their replies.
+func (m *MongoDB) GetComment(postID, id primitive.ObjectID) (*models.Comment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var comment models.Comment
+	if err := m.comments.FindOne(ctx, bson.M{"_id": id, "post_id": postID}).Decode(&comment); err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// GetComments returns the comments on a post, or the replies to parentID if
+// it is not nil, oldest first. Comments by users hidden from viewerID or by
+// deactivated users are left out.
+func (m *MongoDB) GetComments(postID primitive.ObjectID, parentID *primitive.ObjectID, viewerID primitive.ObjectID, limit, offset int64) ([]models.Comment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	hidden, err := m.hiddenUserIDs(ctx, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{
+		"post_id":            postID,
+		"parent_id":          nil,
+		"author_id":          bson.M{"$nin": hidden},
+		"author_deactivated": bson.M{"$ne": true},
+	}
+	if parentID != nil {
+		filter["parent_id"] = *parentID
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip(offset).
+		SetLimit(limit)
+	cursor, err := m.comments.Find(ctx, filter, opts)
+	if err != nil {
+		m.logger.Error().Err(err).Str("post_id", postID.Hex()).Msg("Failed to fetch comments")
+		return nil, err
+	}
+
+	comments := []models.Comment{}
+	if err := cursor.All(ctx, &comments); err != nil {
+		m.logger.Error().Err(err).Str("post_id", postID.Hex()).Msg("Failed to decode comments")
+		return nil, err
+	}
+	return comments, nil
+}
+
+// DeleteComment removes a comment, see removeComment.
+func (m *MongoDB) DeleteComment(comment *models.Comment) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := m.removeComment(ctx, comment); err != nil {
+		m.logger.Error().Err(err).Str("comment_id", comment.ID.Hex()).Msg("Failed to delete comment")
+		return err
+	}
+	return nil
+}
+
+// removeComment deletes a comment without replies. A comment with replies is
+// only marked as deleted and goes once its last reply is removed, which can
+// in turn remove its deleted parent.
+func (m *MongoDB) removeComment(ctx context.Context, comment *models.Comment) error {
+	filter := bson.M{"_id": comment.ID, "replies_count": bson.M{"$lte": 0}}
+	if !comment.Deleted {
+		filter["deleted"] = bson.M{"$ne": true}
+	}
+	result, err := m.comments.DeleteOne(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("failed to delete comment: %w", err)
+	}
+	removed := result.DeletedCount > 0
+
+	if !removed && !comment.Deleted {
+		update := bson.M{
+			"$set":   bson.M{"deleted": true, "content": "", "author_id": primitive.NilObjectID, "likes_count": 0},
+			"$unset": bson.M{"liked_by": "", "author_deactivated": ""},
+		}
+		result, err := m.comments.UpdateOne(ctx, bson.M{"_id": comment.ID, "deleted": bson.M{"$ne": true}}, update)
+		if err != nil {
+			return fmt.Errorf("failed to delete comment: %w", err)
+		}
+		if result.ModifiedCount == 0 {
+			// Deleted by someone else in the meantime.
+			return nil
+		}
+	}
+
+	if !comment.Deleted {
+		if _, err := m.posts.UpdateOne(ctx, bson.M{"_id": comment.PostID}, bson.M{"$inc": bson.M{"comments_count": -1}}); err != nil {
+			return fmt.Errorf("failed to update comments count: %w", err)
+		}
+		if _, err := m.notifications.DeleteMany(ctx, bson.M{"comment_id": comment.ID}); err != nil {
+			return fmt.Errorf("failed to delete comment notifications: %w", err)
+		}
+	}
+
+	if !removed || comment.ParentID == nil {
+		return nil
+	}
+
+	var parent models.Comment
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = m.comments.FindOneAndUpdate(ctx, bson.M{"_id": *comment.ParentID}, bson.M{"$inc": bson.M{"replies_count": -1}}, opts).Decode(&parent)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update parent comment: %w", err)
+	}
+	if parent.Deleted && parent.RepliesCount <= 0 {
+		return m.removeComment(ctx, &parent)
+	}
+	return nil
+}
+
+// LikeComment adds the like of userID to a comment and notifies its author.
+func (m *MongoDB) LikeComment(comment *models.Comment, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": comment.ID, "deleted": bson.M{"$ne": true}, "liked_by": bson.M{"$ne": userID}}
+	update := bson.M{
+		"$inc":      bson.M{"likes_count": 1},
+		"$addToSet": bson.M{"liked_by": userID},
+	}
+	result, err := m.comments.UpdateOne(ctx, filter, update)
+	if err != nil {
+		m.logger.Error().Err(err).Str("comment_id", comment.ID.Hex()).Str("user_id", userID.Hex()).Msg("Failed to like comment")
+		return fmt.Errorf("failed to like comment: %w", err)
+	}
+	if result.ModifiedCount == 0 {
+		return ErrAlreadyLiked
+	}
+
+	if comment.AuthorID != userID {
+		notification := models.NewNotificationComment(models.NotificationTypeCommentLike, comment, comment.AuthorID, userID)
+		if err := m.notify(ctx, notification); err != nil {
+			m.logger.Error().Err(err).Str("comment_id", comment.ID.Hex()).Str("user_id", userID.Hex()).Msg("Failed to insert notification")
+			return err
+		}
+	}
+	return nil
+}
+
+// UnlikeComment removes the like of userID from a comment of postID and
+// withdraws its notification, in case the author has not fetched it yet.
+func (m *MongoDB) UnlikeComment(postID, commentID, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$inc":  bson.M{"likes_count": -1},
+		"$pull": bson.M{"liked_by": userID},
+	}
+	result, err := m.comments.UpdateOne(ctx, bson.M{"_id": commentID, "post_id": postID, "liked_by": userID}, update)
+	if err != nil {
+		m.logger.Error().Err(err).Str("comment_id", commentID.Hex()).Str("user_id", userID.Hex()).Msg("Failed to unlike comment")
+		return fmt.Errorf("failed to unlike comment: %w", err)
+	}
+	if result.ModifiedCount == 0 {
+		return ErrNotLiked
+	}
+
+	notification := bson.M{"type": models.NotificationTypeCommentLike, "comment_id": commentID, "actor_id": userID}
+	if _, err := m.notifications.DeleteMany(ctx, notification); err != nil {
+		m.logger.Error().Err(err).Str("comment_id", commentID.Hex()).Str("user_id", userID.Hex()).Msg("Failed to delete notification")
+		return fmt.Errorf("failed to delete notification: %w", err)
+	}
+	return nil
+}
@@ -2,10 +2,12 @@ package database
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -18,6 +20,18 @@ type MongoDB struct {
 	sessions      *mongo.Collection
 	history       *mongo.Collection
 	posts         *mongo.Collection
+	resets        *mongo.Collection
+	emailChanges  *mongo.Collection
+	loginAttempts *mongo.Collection
+	accessTokens  *mongo.Collection
+	oidcStates    *mongo.Collection
+	identities    *mongo.Collection
+	follows       *mongo.Collection
+	restrictions  *mongo.Collection
+	deletionJobs  *mongo.Collection
+	exports       *mongo.Collection
+	postRevisions *mongo.Collection
+	comments      *mongo.Collection
 
 	logger zerolog.Logger
 }
@@ -32,7 +46,7 @@ func NewMongoDB(uri, database string, logger zerolog.Logger) *MongoDB {
 	}
 
 	db := client.Database(database)
-	return &MongoDB{
+	m := &MongoDB{
 		client:        client,
 		database:      database,
 		users:         db.Collection("users"),
@@ -40,7 +54,114 @@ func NewMongoDB(uri, database string, logger zerolog.Logger) *MongoDB {
 		sessions:      db.Collection("sessions"),
 		history:       db.Collection("history"),
 		notifications: db.Collection("notifications"),
+		resets:        db.Collection("password_resets"),
+		emailChanges:  db.Collection("email_changes"),
+		loginAttempts: db.Collection("login_attempts"),
+		accessTokens:  db.Collection("access_tokens"),
+		oidcStates:    db.Collection("oidc_states"),
+		identities:    db.Collection("identities"),
+		follows:       db.Collection("follows"),
+		restrictions:  db.Collection("restrictions"),
+		deletionJobs:  db.Collection("deletion_jobs"),
+		exports:       db.Collection("data_exports"),
+		postRevisions: db.Collection("post_revisions"),
+		comments:      db.Collection("comments"),
 
 		logger: logger,
 	}
+
+	if err := m.ensureIndexes(ctx); err != nil {
+		log.Fatal().Err(err).Msg("Failed to create MongoDB indexes")
+	}
+
+	return m
+}
+
+// Database returns the underlying database for packages that need direct
+// access, such as the GridFS blob store.
+func (m *MongoDB) Database() *mongo.Database {
+	return m.client.Database(m.database)
+}
+
+func (m *MongoDB) ensureIndexes(ctx context.Context) error {
+	indexes := map[*mongo.Collection][]mongo.IndexModel{
+		m.users: {
+			{Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetUnique(true).SetCollation(nameCollation)},
+			{Keys: bson.D{{Key: "purge_at", Value: 1}}, Options: options.Index().SetSparse(true)},
+			{Keys: bson.D{{Key: "name_lower", Value: 1}}},
+			{Keys: bson.D{{Key: "display_name_words", Value: 1}}},
+		},
+		m.follows: {
+			{Keys: bson.D{{Key: "follower_id", Value: 1}, {Key: "followee_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "follower_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "followee_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "followee_id", Value: 1}, {Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+		},
+		m.restrictions: {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "target_id", Value: 1}, {Key: "kind", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "target_id", Value: 1}, {Key: "kind", Value: 1}}},
+		},
+		m.deletionJobs: {
+			{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "lease_until", Value: 1}}},
+			{Keys: bson.D{{Key: "completed_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(deletionJobRetention)},
+		},
+		m.exports: {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "status", Value: 1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "lease_until", Value: 1}}},
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}},
+		},
+		m.postRevisions: {
+			{Keys: bson.D{{Key: "post_id", Value: 1}, {Key: "replaced_at", Value: -1}}},
+			{Keys: bson.D{{Key: "author_id", Value: 1}}},
+		},
+		m.comments: {
+			{Keys: bson.D{{Key: "post_id", Value: 1}, {Key: "parent_id", Value: 1}, {Key: "created_at", Value: 1}}},
+			{Keys: bson.D{{Key: "author_id", Value: 1}}},
+			{Keys: bson.D{{Key: "liked_by", Value: 1}}},
+		},
+		m.sessions: {
+			{Keys: bson.D{{Key: "token", Value: 1}}},
+			{Keys: bson.D{{Key: "previous_tokens", Value: 1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}}},
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		m.resets: {
+			{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}}},
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		m.emailChanges: {
+			{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}}},
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		m.accessTokens: {
+			{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}}},
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		m.oidcStates: {
+			{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		m.identities: {
+			{Keys: bson.D{{Key: "provider", Value: 1}, {Key: "subject", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		},
+		m.loginAttempts: {
+			{Keys: bson.D{{Key: "updated_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(loginAttemptRetention)},
+		},
+	}
+
+	for collection, models := range indexes {
+		if _, err := collection.Indexes().CreateMany(ctx, models); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return fmt.Errorf("existing %s documents violate a unique index, resolve the duplicates first: %w", collection.Name(), err)
+			}
+			return fmt.Errorf("failed to create indexes on %s: %w", collection.Name(), err)
+		}
+	}
+
+	return nil
 }
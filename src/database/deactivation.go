@@ -0,0 +1,128 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"glamapp/src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DeactivateUser hides the user and their posts, signs them out everywhere
+// and revokes their personal access tokens and pending OIDC flows. The
+// account is purged after purgeAt unless it is reactivated.
+func (m *MongoDB) DeactivateUser(id primitive.ObjectID, purgeAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"deactivated_at": time.Now(), "purge_at": purgeAt}}
+	if _, err := m.users.UpdateOne(ctx, bson.M{"_id": id}, update); err != nil {
+		m.logger.Error().Err(err).Str("user_id", id.Hex()).Msg("Failed to deactivate user")
+		return fmt.Errorf("failed to deactivate user: %w", err)
+	}
+
+	if err := m.setAuthorDeactivated(ctx, id, true); err != nil {
+		return err
+	}
+
+	if _, err := m.sessions.DeleteMany(ctx, bson.M{"user_id": id}); err != nil {
+		m.logger.Error().Err(err).Str("user_id", id.Hex()).Msg("Failed to delete sessions of deactivated user")
+		return fmt.Errorf("failed to delete sessions: %w", err)
+	}
+	if _, err := m.accessTokens.DeleteMany(ctx, bson.M{"user_id": id}); err != nil {
+		m.logger.Error().Err(err).Str("user_id", id.Hex()).Msg("Failed to delete access tokens of deactivated user")
+		return fmt.Errorf("failed to delete access tokens: %w", err)
+	}
+	if _, err := m.oidcStates.DeleteMany(ctx, bson.M{"user_id": id}); err != nil {
+		m.logger.Error().Err(err).Str("user_id", id.Hex()).Msg("Failed to delete OIDC states of deactivated user")
+		return fmt.Errorf("failed to delete OIDC states: %w", err)
+	}
+	return nil
+}
+
+// ReactivateUser restores a deactivated user. It reports false if the grace
+// period is over and the account is about to be purged.
+func (m *MongoDB) ReactivateUser(id primitive.ObjectID) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": id, "purge_at": bson.M{"$gt": time.Now()}}
+	update := bson.M{"$unset": bson.M{"deactivated_at": "", "purge_at": ""}}
+	result, err := m.users.UpdateOne(ctx, filter, update)
+	if err != nil {
+		m.logger.Error().Err(err).Str("user_id", id.Hex()).Msg("Failed to reactivate user")
+		return false, fmt.Errorf("failed to reactivate user: %w", err)
+	}
+	if result.ModifiedCount == 0 {
+		return false, nil
+	}
+
+	return true, m.setAuthorDeactivated(ctx, id, false)
+}
+
+func (m *MongoDB) setAuthorDeactivated(ctx context.Context, authorID primitive.ObjectID, deactivated bool) error {
+	update := bson.M{"$set": bson.M{"author_deactivated": true}}
+	if !deactivated {
+		update = bson.M{"$unset": bson.M{"author_deactivated": ""}}
+	}
+	if _, err := m.posts.UpdateMany(ctx, bson.M{"author_id": authorID}, update); err != nil {
+		m.logger.Error().Err(err).Str("user_id", authorID.Hex()).Msg("Failed to update posts of deactivated user")
+		return fmt.Errorf("failed to update posts: %w", err)
+	}
+	if _, err := m.comments.UpdateMany(ctx, bson.M{"author_id": authorID}, update); err != nil {
+		m.logger.Error().Err(err).Str("user_id", authorID.Hex()).Msg("Failed to update comments of deactivated user")
+		return fmt.Errorf("failed to update comments: %w", err)
+	}
+	return nil
+}
+
+// GetUsersToPurge returns up to limit deactivated users whose grace period
+// ended before now.
+func (m *MongoDB) GetUsersToPurge(now time.Time, limit int64) ([]primitive.ObjectID, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetProjection(bson.M{"_id": 1}).SetSort(bson.D{{Key: "purge_at", Value: 1}}).SetLimit(limit)
+	cursor, err := m.users.Find(ctx, bson.M{"purge_at": bson.M{"$lte": now}}, opts)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to fetch users to purge")
+		return nil, err
+	}
+
+	var users []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &users); err != nil {
+		m.logger.Error().Err(err).Msg("Failed to decode users to purge")
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	return ids, nil
+}
+
+// QueueUserPurge starts the deletion of a user whose grace period is over.
+// Clearing purge_at afterwards keeps the user from being queued again and
+// from being reactivated.
+func (m *MongoDB) QueueUserPurge(id primitive.ObjectID, totalSteps int) (*models.DeletionJob, error) {
+	job, err := m.CreateDeletionJob(id, id, totalSteps)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := m.users.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$unset": bson.M{"purge_at": ""}}); err != nil {
+		m.logger.Error().Err(err).Str("user_id", id.Hex()).Msg("Failed to clear purge time")
+		return job, fmt.Errorf("failed to clear purge time: %w", err)
+	}
+	return job, nil
+}
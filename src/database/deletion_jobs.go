@@ -0,0 +1,182 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"glamapp/src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// deletionJobRetention keeps finished jobs around for 30 days so their
+// outcome can still be looked up.
+const deletionJobRetention = int32(30 * 24 * 60 * 60)
+
+// CreateDeletionJob queues the deletion of a user. There is only ever one
+// job per user; requesting it again returns the existing job and requeues it
+// if it failed.
+func (m *MongoDB) CreateDeletionJob(userID, requestedBy primitive.ObjectID, totalSteps int) (*models.DeletionJob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	update := bson.M{
+		"$setOnInsert": bson.M{
+			"_id":             primitive.NewObjectID(),
+			"requested_by":    requestedBy,
+			"status":          models.DeletionJobPending,
+			"completed_steps": bson.A{},
+			"total_steps":     totalSteps,
+			"attempts":        0,
+			"lease_until":     time.Time{},
+			"created_at":      now,
+		},
+		"$set": bson.M{"updated_at": now},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var job models.DeletionJob
+	err := m.deletionJobs.FindOneAndUpdate(ctx, bson.M{"user_id": userID}, update, opts).Decode(&job)
+	if err != nil {
+		m.logger.Error().Err(err).Str("user_id", userID.Hex()).Msg("Failed to create deletion job")
+		return nil, err
+	}
+
+	if job.Status == models.DeletionJobFailed {
+		retry := bson.M{"$set": bson.M{"status": models.DeletionJobPending, "error": "", "attempts": 0, "lease_until": time.Time{}}}
+		if err := m.deletionJobs.FindOneAndUpdate(ctx, bson.M{"_id": job.ID}, retry, opts).Decode(&job); err != nil {
+			m.logger.Error().Err(err).Str("user_id", userID.Hex()).Msg("Failed to queue deletion job")
+			return nil, err
+		}
+	}
+
+	return &job, nil
+}
+
+// RequeueFailedPurges requeues purge jobs that failed before failedBefore.
+// Nobody else would retry them, and the user can no longer be reactivated
+// once the purge was queued. It returns the number of jobs requeued.
+func (m *MongoDB) RequeueFailedPurges(failedBefore time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Purges are the only jobs queued on behalf of the user themselves.
+	filter := bson.M{
+		"status":     models.DeletionJobFailed,
+		"updated_at": bson.M{"$lt": failedBefore},
+		"$expr":      bson.M{"$eq": bson.A{"$requested_by", "$user_id"}},
+	}
+	update := bson.M{"$set": bson.M{
+		"status":      models.DeletionJobPending,
+		"error":       "",
+		"attempts":    0,
+		"lease_until": time.Time{},
+		"updated_at":  time.Now(),
+	}}
+	result, err := m.deletionJobs.UpdateMany(ctx, filter, update)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to requeue failed purges")
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// ClaimDeletionJob hands out a pending job, or a running one whose worker
+// stopped renewing its lease, and leases it until leaseUntil. It returns
+// mongo.ErrNoDocuments when there is nothing to do.
+func (m *MongoDB) ClaimDeletionJob(leaseUntil time.Time) (*models.DeletionJob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"status":      bson.M{"$in": bson.A{models.DeletionJobPending, models.DeletionJobRunning}},
+		"lease_until": bson.M{"$lt": time.Now()},
+	}
+	update := bson.M{
+		"$set": bson.M{"status": models.DeletionJobRunning, "lease_until": leaseUntil, "updated_at": time.Now()},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var job models.DeletionJob
+	if err := m.deletionJobs.FindOneAndUpdate(ctx, filter, update, opts).Decode(&job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// StartDeletionStep records the step a job is working on and renews its
+// lease.
+func (m *MongoDB) StartDeletionStep(id primitive.ObjectID, step string, leaseUntil time.Time) error {
+	return m.updateDeletionJob(id, bson.M{"$set": bson.M{"step": step, "lease_until": leaseUntil, "updated_at": time.Now()}})
+}
+
+func (m *MongoDB) CompleteDeletionStep(id primitive.ObjectID, step string) error {
+	return m.updateDeletionJob(id, bson.M{
+		"$addToSet": bson.M{"completed_steps": step},
+		"$set":      bson.M{"updated_at": time.Now()},
+	})
+}
+
+func (m *MongoDB) FinishDeletionJob(id primitive.ObjectID) error {
+	now := time.Now()
+	return m.updateDeletionJob(id, bson.M{"$set": bson.M{
+		"status":       models.DeletionJobCompleted,
+		"step":         "",
+		"updated_at":   now,
+		"completed_at": now,
+	}})
+}
+
+// FailDeletionJob gives up on a job. Requesting the deletion again resumes
+// it from the first step that did not complete.
+func (m *MongoDB) FailDeletionJob(id primitive.ObjectID, jobErr error) error {
+	return m.updateDeletionJob(id, bson.M{"$set": bson.M{
+		"status":      models.DeletionJobFailed,
+		"error":       jobErr.Error(),
+		"lease_until": time.Time{},
+		"updated_at":  time.Now(),
+	}})
+}
+
+// ReleaseDeletionJob returns a job to the queue to be retried after retryAt.
+func (m *MongoDB) ReleaseDeletionJob(id primitive.ObjectID, jobErr error, retryAt time.Time) error {
+	return m.updateDeletionJob(id, bson.M{"$set": bson.M{
+		"status":      models.DeletionJobPending,
+		"error":       jobErr.Error(),
+		"lease_until": retryAt,
+		"updated_at":  time.Now(),
+	}})
+}
+
+func (m *MongoDB) updateDeletionJob(id primitive.ObjectID, update bson.M) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := m.deletionJobs.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		m.logger.Error().Err(err).Str("job_id", id.Hex()).Msg("Failed to update deletion job")
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (m *MongoDB) GetDeletionJobByUser(userID primitive.ObjectID) (*models.DeletionJob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var job models.DeletionJob
+	if err := m.deletionJobs.FindOne(ctx, bson.M{"user_id": userID}).Decode(&job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
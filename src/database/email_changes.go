@@ -0,0 +1,63 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"glamapp/src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateEmailChange stores a token confirming email as the user's new
+// address and invalidates any change requested earlier.
+func (m *MongoDB) CreateEmailChange(userID primitive.ObjectID, email, tokenHash string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := m.emailChanges.DeleteMany(ctx, bson.M{"user_id": userID, "used_at": nil}); err != nil {
+		m.logger.Error().Err(err).Str("user_id", userID.Hex()).Msg("Failed to delete previous email changes")
+		return err
+	}
+
+	change := &models.EmailChange{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Email:     email,
+		TokenHash: tokenHash,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+
+	if _, err := m.emailChanges.InsertOne(ctx, change); err != nil {
+		m.logger.Error().Err(err).Str("user_id", userID.Hex()).Msg("Failed to create email change")
+		return err
+	}
+
+	return nil
+}
+
+// ConsumeEmailChange marks an unused, unexpired token as used and returns
+// the change, like ConsumePasswordReset.
+func (m *MongoDB) ConsumeEmailChange(tokenHash string) (*models.EmailChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{
+		"token_hash": tokenHash,
+		"used_at":    nil,
+		"expires_at": bson.M{"$gt": now},
+	}
+	update := bson.M{"$set": bson.M{"used_at": now}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var change models.EmailChange
+	if err := m.emailChanges.FindOneAndUpdate(ctx, filter, update, opts).Decode(&change); err != nil {
+		return nil, err
+	}
+
+	return &change, nil
+}
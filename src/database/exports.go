@@ -0,0 +1,240 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"glamapp/src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrExportTooSoon is returned by CreateDataExport together with the latest
+// export when the user already got one within the interval.
+var ErrExportTooSoon = errors.New("data export requested too soon")
+
+// CreateDataExport queues a new export for the user. If one is still being
+// built it is returned instead and created is false. Only one export is
+// built per interval; failed exports do not count.
+func (m *MongoDB) CreateDataExport(userID primitive.ObjectID, interval time.Duration) (export *models.DataExport, created bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	active := bson.M{
+		"user_id": userID,
+		"status":  bson.M{"$in": bson.A{models.DataExportPending, models.DataExportRunning}},
+	}
+	var existing models.DataExport
+	err = m.exports.FindOne(ctx, active).Decode(&existing)
+	if err == nil {
+		return &existing, false, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		m.logger.Error().Err(err).Str("user_id", userID.Hex()).Msg("Failed to fetch data exports")
+		return nil, false, err
+	}
+
+	recent := bson.M{
+		"user_id":    userID,
+		"status":     models.DataExportCompleted,
+		"created_at": bson.M{"$gt": time.Now().Add(-interval)},
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	err = m.exports.FindOne(ctx, recent, opts).Decode(&existing)
+	if err == nil {
+		return &existing, false, ErrExportTooSoon
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		m.logger.Error().Err(err).Str("user_id", userID.Hex()).Msg("Failed to fetch data exports")
+		return nil, false, err
+	}
+
+	export = &models.DataExport{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Status:    models.DataExportPending,
+		CreatedAt: time.Now(),
+	}
+	if _, err := m.exports.InsertOne(ctx, export); err != nil {
+		m.logger.Error().Err(err).Str("user_id", userID.Hex()).Msg("Failed to insert data export")
+		return nil, false, fmt.Errorf("failed to insert data export: %w", err)
+	}
+	return export, true, nil
+}
+
+// ClaimDataExport hands out a pending export, or a running one whose worker
+// stopped renewing its lease, and leases it until leaseUntil. It returns
+// mongo.ErrNoDocuments when there is nothing to do.
+func (m *MongoDB) ClaimDataExport(leaseUntil time.Time) (*models.DataExport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"status":      bson.M{"$in": bson.A{models.DataExportPending, models.DataExportRunning}},
+		"lease_until": bson.M{"$lt": time.Now()},
+	}
+	update := bson.M{
+		"$set": bson.M{"status": models.DataExportRunning, "lease_until": leaseUntil},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var export models.DataExport
+	if err := m.exports.FindOneAndUpdate(ctx, filter, update, opts).Decode(&export); err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+func (m *MongoDB) FinishDataExport(id primitive.ObjectID, blobKey string, size int64, expiresAt time.Time) error {
+	return m.updateDataExport(id, bson.M{"$set": bson.M{
+		"status":       models.DataExportCompleted,
+		"blob_key":     blobKey,
+		"size":         size,
+		"completed_at": time.Now(),
+		"expires_at":   expiresAt,
+	}})
+}
+
+func (m *MongoDB) FailDataExport(id primitive.ObjectID, exportErr error) error {
+	return m.updateDataExport(id, bson.M{"$set": bson.M{
+		"status":      models.DataExportFailed,
+		"error":       exportErr.Error(),
+		"lease_until": time.Time{},
+	}})
+}
+
+func (m *MongoDB) updateDataExport(id primitive.ObjectID, update bson.M) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := m.exports.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		m.logger.Error().Err(err).Str("export_id", id.Hex()).Msg("Failed to update data export")
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// GetDataExport returns an export of userID, so users cannot look up each
+// other's exports.
+func (m *MongoDB) GetDataExport(id, userID primitive.ObjectID) (*models.DataExport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var export models.DataExport
+	if err := m.exports.FindOne(ctx, bson.M{"_id": id, "user_id": userID}).Decode(&export); err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// GetExpiredDataExports returns finished exports whose archive should be
+// removed. Failed exports expire a day after they were requested.
+func (m *MongoDB) GetExpiredDataExports(now time.Time) ([]models.DataExport, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"expires_at": bson.M{"$lt": now}},
+		bson.M{"status": models.DataExportFailed, "created_at": bson.M{"$lt": now.Add(-24 * time.Hour)}},
+	}}
+	return m.findDataExports(filter)
+}
+
+// GetUserDataExports returns every export of the user, for account deletion.
+func (m *MongoDB) GetUserDataExports(userID primitive.ObjectID) ([]models.DataExport, error) {
+	return m.findDataExports(bson.M{"user_id": userID})
+}
+
+func (m *MongoDB) findDataExports(filter bson.M) ([]models.DataExport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := m.exports.Find(ctx, filter)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to fetch data exports")
+		return nil, err
+	}
+
+	exports := []models.DataExport{}
+	if err := cursor.All(ctx, &exports); err != nil {
+		m.logger.Error().Err(err).Msg("Failed to decode data exports")
+		return nil, err
+	}
+	return exports, nil
+}
+
+func (m *MongoDB) DeleteDataExport(id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := m.exports.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// The methods below read the user's data for an export. They stream the
+// documents to visit instead of loading them all at once.
+
+func (m *MongoDB) ExportUserPosts(ctx context.Context, userID primitive.ObjectID, visit func(*models.Post) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	return eachDocument(ctx, m.posts, bson.M{"author_id": userID}, opts, visit)
+}
+
+func (m *MongoDB) ExportUserPostRevisions(ctx context.Context, userID primitive.ObjectID, visit func(*models.PostRevision) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "replaced_at", Value: 1}})
+	return eachDocument(ctx, m.postRevisions, bson.M{"author_id": userID}, opts, visit)
+}
+
+func (m *MongoDB) ExportUserComments(ctx context.Context, userID primitive.ObjectID, visit func(*models.Comment) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	return eachDocument(ctx, m.comments, bson.M{"author_id": userID, "deleted": bson.M{"$ne": true}}, opts, visit)
+}
+
+// ExportUserLikes visits the posts the user liked.
+func (m *MongoDB) ExportUserLikes(ctx context.Context, userID primitive.ObjectID, visit func(*models.Post) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	return eachDocument(ctx, m.posts, bson.M{"liked_by": userID}, opts, visit)
+}
+
+// ExportUserNotifications visits the notifications waiting for the user.
+func (m *MongoDB) ExportUserNotifications(ctx context.Context, userID primitive.ObjectID, visit func(*models.Notification) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	return eachDocument(ctx, m.notifications, bson.M{"liked_by": userID}, opts, visit)
+}
+
+func (m *MongoDB) ExportUserSessions(ctx context.Context, userID primitive.ObjectID, visit func(*models.Session) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	return eachDocument(ctx, m.sessions, bson.M{"user_id": userID}, opts, visit)
+}
+
+func (m *MongoDB) ExportUserHistory(ctx context.Context, userID primitive.ObjectID, visit func(*models.History) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}})
+	return eachDocument(ctx, m.history, bson.M{"user_id": userID}, opts, visit)
+}
+
+func eachDocument[T any](ctx context.Context, collection *mongo.Collection, filter bson.M, opts *options.FindOptions, visit func(*T) error) error {
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", collection.Name(), err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		doc := new(T)
+		if err := cursor.Decode(doc); err != nil {
+			return fmt.Errorf("failed to decode %s: %w", collection.Name(), err)
+		}
+		if err := visit(doc); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
@@ -0,0 +1,322 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"glamapp/src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Follow makes followerID follow followee and notifies the followee. Private
+// accounts get a pending follow request instead. It returns the follow and
+// reports false if the relationship or request already existed.
+func (m *MongoDB) Follow(followerID primitive.ObjectID, followee *models.User) (*models.Follow, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	followeeID := followee.ID
+	follow := &models.Follow{
+		ID:         primitive.NewObjectID(),
+		FollowerID: followerID,
+		FolloweeID: followeeID,
+		Status:     models.FollowAccepted,
+		CreatedAt:  time.Now(),
+	}
+	if followee.Private {
+		follow.Status = models.FollowPending
+	}
+
+	if _, err := m.follows.InsertOne(ctx, follow); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			existing := new(models.Follow)
+			if err := m.follows.FindOne(ctx, bson.M{"follower_id": followerID, "followee_id": followeeID}).Decode(existing); err != nil {
+				return nil, false, fmt.Errorf("failed to fetch follow: %w", err)
+			}
+			return existing, false, nil
+		}
+		m.logger.Error().Err(err).Str("follower_id", followerID.Hex()).Str("followee_id", followeeID.Hex()).Msg("Failed to insert follow")
+		return nil, false, fmt.Errorf("failed to insert follow: %w", err)
+	}
+
+	notification := models.NewNotificationFollowRequest(followeeID, followerID)
+	if !follow.Pending() {
+		if err := m.incFollowCounts(ctx, followerID, followeeID, 1); err != nil {
+			return follow, true, err
+		}
+		notification = models.NewNotificationFollow(followeeID, followerID)
+	}
+
+	if err := m.notify(ctx, notification); err != nil {
+		m.logger.Error().Err(err).Str("follower_id", followerID.Hex()).Str("followee_id", followeeID.Hex()).Msg("Failed to insert notification")
+	}
+
+	return follow, true, nil
+}
+
+// Unfollow removes the relationship, or withdraws a pending request, and
+// reports false if there was none.
+func (m *MongoDB) Unfollow(followerID, followeeID primitive.ObjectID) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var follow models.Follow
+	err := m.follows.FindOneAndDelete(ctx, bson.M{"follower_id": followerID, "followee_id": followeeID}).Decode(&follow)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	if err != nil {
+		m.logger.Error().Err(err).Str("follower_id", followerID.Hex()).Str("followee_id", followeeID.Hex()).Msg("Failed to delete follow")
+		return false, fmt.Errorf("failed to delete follow: %w", err)
+	}
+	if follow.Pending() {
+		return true, nil
+	}
+
+	return true, m.incFollowCounts(ctx, followerID, followeeID, -1)
+}
+
+// ApproveFollowRequest accepts the pending request of followerID to follow
+// followeeID and reports false if there was none.
+func (m *MongoDB) ApproveFollowRequest(followeeID, followerID primitive.ObjectID) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"follower_id": followerID, "followee_id": followeeID, "status": models.FollowPending}
+	result, err := m.follows.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"status": models.FollowAccepted}})
+	if err != nil {
+		m.logger.Error().Err(err).Str("follower_id", followerID.Hex()).Str("followee_id", followeeID.Hex()).Msg("Failed to approve follow request")
+		return false, fmt.Errorf("failed to approve follow request: %w", err)
+	}
+	if result.ModifiedCount == 0 {
+		return false, nil
+	}
+
+	return true, m.incFollowCounts(ctx, followerID, followeeID, 1)
+}
+
+// RejectFollowRequest deletes the pending request of followerID to follow
+// followeeID and reports false if there was none.
+func (m *MongoDB) RejectFollowRequest(followeeID, followerID primitive.ObjectID) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"follower_id": followerID, "followee_id": followeeID, "status": models.FollowPending}
+	result, err := m.follows.DeleteOne(ctx, filter)
+	if err != nil {
+		m.logger.Error().Err(err).Str("follower_id", followerID.Hex()).Str("followee_id", followeeID.Hex()).Msg("Failed to reject follow request")
+		return false, fmt.Errorf("failed to reject follow request: %w", err)
+	}
+	return result.DeletedCount == 1, nil
+}
+
+// acceptFollowRequests accepts every pending request of a user that made
+// their account public. Each request is accepted on its own and only counted
+// if it was still pending, so requests cancelled or accepted in the
+// meantime do not skew the counts.
+func (m *MongoDB) acceptFollowRequests(ctx context.Context, userID primitive.ObjectID) error {
+	filter := bson.M{"followee_id": userID, "status": models.FollowPending}
+	cursor, err := m.follows.Find(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("failed to fetch follow requests: %w", err)
+	}
+
+	var requests []models.Follow
+	if err := cursor.All(ctx, &requests); err != nil {
+		return fmt.Errorf("failed to decode follow requests: %w", err)
+	}
+
+	for _, request := range requests {
+		pending := bson.M{"_id": request.ID, "status": models.FollowPending}
+		result, err := m.follows.UpdateOne(ctx, pending, bson.M{"$set": bson.M{"status": models.FollowAccepted}})
+		if err != nil {
+			return fmt.Errorf("failed to accept follow request: %w", err)
+		}
+		if result.ModifiedCount == 0 {
+			continue
+		}
+		if err := m.incFollowCounts(ctx, request.FollowerID, userID, 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsFollowing reports whether followerID is an approved follower of
+// followeeID.
+func (m *MongoDB) IsFollowing(followerID, followeeID primitive.ObjectID) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return m.isFollowing(ctx, followerID, followeeID)
+}
+
+func (m *MongoDB) isFollowing(ctx context.Context, followerID, followeeID primitive.ObjectID) (bool, error) {
+	filter := bson.M{"follower_id": followerID, "followee_id": followeeID, "status": bson.M{"$ne": models.FollowPending}}
+	count, err := m.follows.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		m.logger.Error().Err(err).Str("follower_id", followerID.Hex()).Str("followee_id", followeeID.Hex()).Msg("Failed to check follow")
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// followingIDs returns everyone userID follows with an approved follow.
+func (m *MongoDB) followingIDs(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	filter := bson.M{"follower_id": userID, "status": bson.M{"$ne": models.FollowPending}}
+	cursor, err := m.follows.Find(ctx, filter, options.Find().SetProjection(bson.M{"followee_id": 1}))
+	if err != nil {
+		m.logger.Error().Err(err).Str("user_id", userID.Hex()).Msg("Failed to fetch follows")
+		return nil, err
+	}
+
+	var follows []models.Follow
+	if err := cursor.All(ctx, &follows); err != nil {
+		m.logger.Error().Err(err).Str("user_id", userID.Hex()).Msg("Failed to decode follows")
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(follows))
+	for _, follow := range follows {
+		ids = append(ids, follow.FolloweeID)
+	}
+	return ids, nil
+}
+
+func (m *MongoDB) incFollowCounts(ctx context.Context, followerID, followeeID primitive.ObjectID, delta int64) error {
+	if _, err := m.users.UpdateOne(ctx, bson.M{"_id": followerID}, bson.M{"$inc": bson.M{"following_count": delta}}); err != nil {
+		m.logger.Error().Err(err).Str("user_id", followerID.Hex()).Msg("Failed to update following count")
+		return fmt.Errorf("failed to update following count: %w", err)
+	}
+	if _, err := m.users.UpdateOne(ctx, bson.M{"_id": followeeID}, bson.M{"$inc": bson.M{"followers_count": delta}}); err != nil {
+		m.logger.Error().Err(err).Str("user_id", followeeID.Hex()).Msg("Failed to update followers count")
+		return fmt.Errorf("failed to update followers count: %w", err)
+	}
+	return nil
+}
+
+// GetFollowers returns the users following userID, most recent first.
+func (m *MongoDB) GetFollowers(userID primitive.ObjectID, limit, offset int64) ([]models.User, error) {
+	return m.followUsers(bson.M{"followee_id": userID, "status": bson.M{"$ne": models.FollowPending}}, "follower_id", limit, offset)
+}
+
+// GetFollowing returns the users userID follows, most recent first.
+func (m *MongoDB) GetFollowing(userID primitive.ObjectID, limit, offset int64) ([]models.User, error) {
+	return m.followUsers(bson.M{"follower_id": userID, "status": bson.M{"$ne": models.FollowPending}}, "followee_id", limit, offset)
+}
+
+// GetFollowRequests returns the users waiting for userID to approve their
+// follow request, most recent first.
+func (m *MongoDB) GetFollowRequests(userID primitive.ObjectID, limit, offset int64) ([]models.User, error) {
+	return m.followUsers(bson.M{"followee_id": userID, "status": models.FollowPending}, "follower_id", limit, offset)
+}
+
+func (m *MongoDB) followUsers(filter bson.M, field string, limit, offset int64) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetSkip(offset).SetLimit(limit)
+	cursor, err := m.follows.Find(ctx, filter, opts)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to fetch follows")
+		return nil, err
+	}
+
+	var follows []models.Follow
+	if err := cursor.All(ctx, &follows); err != nil {
+		m.logger.Error().Err(err).Msg("Failed to decode follows")
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(follows))
+	for _, follow := range follows {
+		if field == "follower_id" {
+			ids = append(ids, follow.FollowerID)
+		} else {
+			ids = append(ids, follow.FolloweeID)
+		}
+	}
+
+	return m.getUsersByIDs(ctx, ids)
+}
+
+// getUsersByIDs returns the users in the order of ids, skipping users that
+// no longer exist or are deactivated.
+func (m *MongoDB) getUsersByIDs(ctx context.Context, ids []primitive.ObjectID) ([]models.User, error) {
+	users := []models.User{}
+	if len(ids) == 0 {
+		return users, nil
+	}
+
+	cursor, err := m.users.Find(ctx, bson.M{"_id": bson.M{"$in": ids}, "deactivated_at": bson.M{"$exists": false}})
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to fetch users")
+		return nil, err
+	}
+
+	var found []models.User
+	if err := cursor.All(ctx, &found); err != nil {
+		m.logger.Error().Err(err).Msg("Failed to decode users")
+		return nil, err
+	}
+
+	byID := make(map[primitive.ObjectID]models.User, len(found))
+	for _, user := range found {
+		byID[user.ID] = user
+	}
+	for _, id := range ids {
+		if user, ok := byID[id]; ok {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+// deleteUserFollows removes every relationship of a deleted user and fixes
+// the counts of the users on the other side.
+func (m *MongoDB) deleteUserFollows(ctx context.Context, userID primitive.ObjectID) error {
+	filter := bson.M{"$or": bson.A{bson.M{"follower_id": userID}, bson.M{"followee_id": userID}}}
+	cursor, err := m.follows.Find(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("failed to fetch follows: %w", err)
+	}
+
+	var follows []models.Follow
+	if err := cursor.All(ctx, &follows); err != nil {
+		return fmt.Errorf("failed to decode follows: %w", err)
+	}
+
+	var followees, followers []primitive.ObjectID
+	for _, follow := range follows {
+		if follow.Pending() {
+			continue
+		}
+		if follow.FollowerID == userID {
+			followees = append(followees, follow.FolloweeID)
+		} else {
+			followers = append(followers, follow.FollowerID)
+		}
+	}
+
+	if len(followees) > 0 {
+		if _, err := m.users.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": followees}}, bson.M{"$inc": bson.M{"followers_count": -1}}); err != nil {
+			return fmt.Errorf("failed to update followers counts: %w", err)
+		}
+	}
+	if len(followers) > 0 {
+		if _, err := m.users.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": followers}}, bson.M{"$inc": bson.M{"following_count": -1}}); err != nil {
+			return fmt.Errorf("failed to update following counts: %w", err)
+		}
+	}
+
+	if _, err := m.follows.DeleteMany(ctx, filter); err != nil {
+		return fmt.Errorf("failed to delete follows: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"glamapp/src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (m *MongoDB) CreateOIDCState(state *models.OIDCState) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := m.oidcStates.InsertOne(ctx, state); err != nil {
+		m.logger.Error().Err(err).Str("provider", state.Provider).Msg("Failed to create OIDC state")
+		return err
+	}
+	return nil
+}
+
+// ConsumeOIDCState returns and deletes an unexpired state, so every
+// authorization response can only be redeemed once.
+func (m *MongoDB) ConsumeOIDCState(id string) (*models.OIDCState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var state models.OIDCState
+	err := m.oidcStates.FindOneAndDelete(ctx, bson.M{"_id": id, "expires_at": bson.M{"$gt": time.Now()}}).Decode(&state)
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// BindOIDCState binds an unexpired link state that no browser opened yet to
+// browserHash. Each link request can only be opened once.
+func (m *MongoDB) BindOIDCState(id, browserHash string) (*models.OIDCState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":          id,
+		"user_id":      bson.M{"$exists": true},
+		"browser_hash": "",
+		"expires_at":   bson.M{"$gt": time.Now()},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var state models.OIDCState
+	err := m.oidcStates.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"browser_hash": browserHash}}, opts).Decode(&state)
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func (m *MongoDB) GetIdentity(provider, subject string) (*models.Identity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var identity models.Identity
+	if err := m.identities.FindOne(ctx, bson.M{"provider": provider, "subject": subject}).Decode(&identity); err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (m *MongoDB) CreateIdentity(identity *models.Identity) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	identity.ID = primitive.NewObjectID()
+	identity.CreatedAt = time.Now()
+
+	if _, err := m.identities.InsertOne(ctx, identity); err != nil {
+		m.logger.Error().Err(err).Str("provider", identity.Provider).Str("user_id", identity.UserID.Hex()).Msg("Failed to create identity")
+		return err
+	}
+	return nil
+}
+
+func (m *MongoDB) GetUserIdentities(userID primitive.ObjectID) ([]models.Identity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := m.identities.Find(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		m.logger.Error().Err(err).Str("user_id", userID.Hex()).Msg("Failed to fetch identities")
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	identities := []models.Identity{}
+	if err = cursor.All(ctx, &identities); err != nil {
+		m.logger.Error().Err(err).Str("user_id", userID.Hex()).Msg("Failed to decode identities")
+		return nil, err
+	}
+	return identities, nil
+}
+
+func (m *MongoDB) DeleteUserIdentity(userID, identityID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := m.identities.DeleteOne(ctx, bson.M{"_id": identityID, "user_id": userID})
+	if err != nil {
+		m.logger.Error().Err(err).Str("identity_id", identityID.Hex()).Msg("Failed to delete identity")
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
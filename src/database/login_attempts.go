@@ -0,0 +1,116 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"glamapp/src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Failure counters are forgotten a day after the last failed attempt.
+const loginAttemptRetention = int32(24 * 60 * 60)
+
+// LockoutPolicy describes when repeated login failures lock a key and for how
+// long. The lock doubles with every failure past the threshold.
+type LockoutPolicy struct {
+	MaxFailures int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+func (p LockoutPolicy) delay(failures int) time.Duration {
+	if failures < p.MaxFailures {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.MaxFailures; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// GetLoginLockout returns until when the most restrictive of keys is locked,
+// or the zero time if none of them is.
+func (m *MongoDB) GetLoginLockout(keys ...string) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := m.loginAttempts.Find(ctx, bson.M{
+		"_id":          bson.M{"$in": keys},
+		"locked_until": bson.M{"$gt": time.Now()},
+	})
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to fetch login attempts")
+		return time.Time{}, err
+	}
+	defer cursor.Close(ctx)
+
+	var attempts []models.LoginAttempt
+	if err = cursor.All(ctx, &attempts); err != nil {
+		m.logger.Error().Err(err).Msg("Failed to decode login attempts")
+		return time.Time{}, err
+	}
+
+	var lockedUntil time.Time
+	for _, attempt := range attempts {
+		if attempt.LockedUntil.After(lockedUntil) {
+			lockedUntil = attempt.LockedUntil
+		}
+	}
+
+	return lockedUntil, nil
+}
+
+// RecordLoginFailure counts a failed attempt for key and locks it according
+// to policy once it failed too often.
+func (m *MongoDB) RecordLoginFailure(key string, policy LockoutPolicy) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var attempt models.LoginAttempt
+	err := m.loginAttempts.FindOneAndUpdate(ctx, bson.M{"_id": key}, bson.M{
+		"$inc": bson.M{"failures": 1},
+		"$set": bson.M{"updated_at": now},
+	}, opts).Decode(&attempt)
+	if err != nil {
+		m.logger.Error().Err(err).Str("key", key).Msg("Failed to record login failure")
+		return err
+	}
+
+	delay := policy.delay(attempt.Failures)
+	if delay == 0 {
+		return nil
+	}
+
+	_, err = m.loginAttempts.UpdateOne(ctx, bson.M{"_id": key}, bson.M{
+		"$set": bson.M{"locked_until": now.Add(delay)},
+	})
+	if err != nil {
+		m.logger.Error().Err(err).Str("key", key).Msg("Failed to lock login key")
+		return err
+	}
+
+	m.logger.Warn().Str("key", key).Int("failures", attempt.Failures).Dur("delay", delay).Msg("Login locked after repeated failures")
+	return nil
+}
+
+func (m *MongoDB) ResetLoginFailures(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := m.loginAttempts.DeleteOne(ctx, bson.M{"_id": key})
+	if err != nil {
+		m.logger.Error().Err(err).Str("key", key).Msg("Failed to reset login failures")
+	}
+	return err
+}
@@ -0,0 +1,37 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutPolicyDelay(t *testing.T) {
+	policy := LockoutPolicy{MaxFailures: 5, BaseDelay: time.Second, MaxDelay: time.Minute}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{4, 0},
+		{5, time.Second},
+		{6, 2 * time.Second},
+		{7, 4 * time.Second},
+		{10, 32 * time.Second},
+		{11, time.Minute},
+		{1000, time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := policy.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLockoutPolicyDelayCapsBaseDelay(t *testing.T) {
+	policy := LockoutPolicy{MaxFailures: 1, BaseDelay: 2 * time.Minute, MaxDelay: time.Minute}
+	if got := policy.delay(1); got != time.Minute {
+		t.Errorf("delay(1) = %v, want %v", got, time.Minute)
+	}
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"glamapp/src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreatePasswordReset stores a new reset token for the user and invalidates
+// any token requested earlier.
+func (m *MongoDB) CreatePasswordReset(userID primitive.ObjectID, tokenHash string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := m.resets.DeleteMany(ctx, bson.M{"user_id": userID, "used_at": nil}); err != nil {
+		m.logger.Error().Err(err).Str("user_id", userID.Hex()).Msg("Failed to delete previous password resets")
+		return err
+	}
+
+	reset := &models.PasswordReset{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		TokenHash: tokenHash,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+
+	if _, err := m.resets.InsertOne(ctx, reset); err != nil {
+		m.logger.Error().Err(err).Str("user_id", userID.Hex()).Msg("Failed to create password reset")
+		return err
+	}
+
+	return nil
+}
+
+// ConsumePasswordReset marks an unused, unexpired reset token as used and
+// returns it. The lookup and the update are a single operation so a token
+// cannot be redeemed twice.
+func (m *MongoDB) ConsumePasswordReset(tokenHash string) (*models.PasswordReset, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{
+		"token_hash": tokenHash,
+		"used_at":    nil,
+		"expires_at": bson.M{"$gt": now},
+	}
+	update := bson.M{"$set": bson.M{"used_at": now}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var reset models.PasswordReset
+	if err := m.resets.FindOneAndUpdate(ctx, filter, update, opts).Decode(&reset); err != nil {
+		return nil, err
+	}
+
+	return &reset, nil
+}
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"glamapp/src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrEditConflict is returned when a post was changed by another request
+// while it was being edited.
+var ErrEditConflict = errors.New("post was edited concurrently")
+
+// EditPost replaces the content of post and keeps the previous version as a
+// revision. The update only applies while post is still current. The
+// revision is stored first, so an edit is never left without one, and
+// removed again if the post cannot be updated.
+func (m *MongoDB) EditPost(post *models.Post, content string) (*models.Post, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	written := post.CreatedAt
+	if post.EditedAt != nil {
+		written = *post.EditedAt
+	}
+	revision := models.PostRevision{
+		ID:         primitive.NewObjectID(),
+		PostID:     post.ID,
+		AuthorID:   post.AuthorID,
+		Content:    post.Content,
+		CreatedAt:  written,
+		ReplacedAt: now,
+	}
+	if _, err := m.postRevisions.InsertOne(ctx, revision); err != nil {
+		m.logger.Error().Err(err).Str("post_id", post.ID.Hex()).Msg("Failed to insert post revision")
+		return nil, fmt.Errorf("failed to insert post revision: %w", err)
+	}
+
+	filter := bson.M{"_id": post.ID, "content": post.Content}
+	update := bson.M{"$set": bson.M{"content": content, "edited_at": now, "updated_at": now}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var edited models.Post
+	if err := m.posts.FindOneAndUpdate(ctx, filter, update, opts).Decode(&edited); err != nil {
+		if _, err := m.postRevisions.DeleteOne(ctx, bson.M{"_id": revision.ID}); err != nil {
+			m.logger.Error().Err(err).Str("post_id", post.ID.Hex()).Msg("Failed to remove post revision")
+		}
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrEditConflict
+		}
+		m.logger.Error().Err(err).Str("post_id", post.ID.Hex()).Msg("Failed to edit post")
+		return nil, fmt.Errorf("failed to edit post: %w", err)
+	}
+
+	return &edited, nil
+}
+
+// GetPostRevisions returns the earlier versions of a post, most recent
+// first.
+func (m *MongoDB) GetPostRevisions(postID primitive.ObjectID, limit, offset int64) ([]models.PostRevision, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "replaced_at", Value: -1}}).SetSkip(offset).SetLimit(limit)
+	cursor, err := m.postRevisions.Find(ctx, bson.M{"post_id": postID}, opts)
+	if err != nil {
+		m.logger.Error().Err(err).Str("post_id", postID.Hex()).Msg("Failed to fetch post revisions")
+		return nil, err
+	}
+
+	revisions := []models.PostRevision{}
+	if err := cursor.All(ctx, &revisions); err != nil {
+		m.logger.Error().Err(err).Str("post_id", postID.Hex()).Msg("Failed to decode post revisions")
+		return nil, err
+	}
+	return revisions, nil
+}
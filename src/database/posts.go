@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"glamapp/src/models"
 	"time"
@@ -11,6 +12,9 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// ErrNotLiked is returned when unliking a post the user has not liked.
+var ErrNotLiked = errors.New("post not liked")
+
 func (m *MongoDB) CreatePost(post *models.Post, userID primitive.ObjectID) (string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -65,11 +69,17 @@ func (m *MongoDB) GetPost(id string) (*models.Post, error) {
 	return &post, nil
 }
 
-func (m *MongoDB) GetPosts() ([]models.Post, error) {
+// GetPosts returns all posts viewerID may see, see postVisibility.
+func (m *MongoDB) GetPosts(viewerID primitive.ObjectID) ([]models.Post, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	cursor, err := m.posts.Find(ctx, bson.M{})
+	visible, err := m.postVisibility(ctx, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := m.posts.Find(ctx, visible)
 	if err != nil {
 		return nil, err
 	}
@@ -93,6 +103,30 @@ func (m *MongoDB) LikePost(postID string, userID primitive.ObjectID) error {
 		return fmt.Errorf("invalid post ID: %w", err)
 	}
 
+	post := models.Post{}
+	err = m.posts.FindOne(ctx, bson.M{"_id": postObjectID, "author_deactivated": bson.M{"$ne": true}}).Decode(&post)
+	if err != nil {
+		return fmt.Errorf("post already liked or not found")
+	}
+
+	blocked, err := m.isBlocked(ctx, userID, post.AuthorID)
+	if err != nil {
+		return fmt.Errorf("failed to check block: %w", err)
+	}
+	if blocked {
+		return ErrBlocked
+	}
+
+	if post.AuthorPrivate && post.AuthorID != userID {
+		following, err := m.isFollowing(ctx, userID, post.AuthorID)
+		if err != nil {
+			return fmt.Errorf("failed to check follow: %w", err)
+		}
+		if !following {
+			return ErrPrivate
+		}
+	}
+
 	postUpdate := bson.M{
 		"$inc":      bson.M{"likes_count": 1},
 		"$addToSet": bson.M{"liked_by": userID},
@@ -127,24 +161,91 @@ func (m *MongoDB) LikePost(postID string, userID primitive.ObjectID) error {
 		m.logger.Warn().Str("post_id", postID).Str("user_id", userID.Hex()).Msg("User not found or post already in liked_posts")
 	}
 
-	post := models.Post{}
-	err = m.posts.FindOne(ctx, bson.M{"_id": postObjectID}).Decode(&post)
+	notification := models.NewNotificationLike(postObjectID, post.AuthorID, userID)
+	if err := m.notify(ctx, notification); err != nil {
+		m.logger.Error().Err(err).Str("post_id", postID).Str("user_id", userID.Hex()).Msg("Failed to insert notification")
+		return fmt.Errorf("failed to insert notification: %w", err)
+	}
+
+	m.logger.Info().Str("post_id", postID).Str("user_id", userID.Hex()).Msg("Post liked successfully")
+	return nil
+}
+
+// UnlikePost removes the like of userID from a post. The like and the count
+// are changed in one update, so concurrent unlikes cannot decrement twice.
+// The like notification is withdrawn as well, in case the author has not
+// fetched it yet.
+func (m *MongoDB) UnlikePost(postID string, userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	postObjectID, err := primitive.ObjectIDFromHex(postID)
 	if err != nil {
-		m.logger.Error().Err(err).Str("post_id", postID).Str("user_id", userID.Hex()).Msg("Failed to get post")
-		return fmt.Errorf("failed to get post: %w", err)
+		return fmt.Errorf("invalid post ID: %w", err)
 	}
 
-	notification := models.NewNotificationLike(postObjectID, post.AuthorID)
-	_, err = m.notifications.InsertOne(ctx, notification)
+	postUpdate := bson.M{
+		"$inc":  bson.M{"likes_count": -1},
+		"$pull": bson.M{"liked_by": userID},
+		"$set":  bson.M{"updated_at": time.Now()},
+	}
+	result, err := m.posts.UpdateOne(ctx, bson.M{"_id": postObjectID, "liked_by": userID}, postUpdate)
 	if err != nil {
-		m.logger.Error().Err(err).Str("post_id", postID).Str("user_id", userID.Hex()).Msg("Failed to insert notification")
-		return fmt.Errorf("failed to insert notification: %w", err)
+		m.logger.Error().Err(err).Str("post_id", postID).Str("user_id", userID.Hex()).Msg("Failed to update post")
+		return fmt.Errorf("failed to update post: %w", err)
+	}
+	if result.ModifiedCount == 0 {
+		return ErrNotLiked
 	}
 
-	m.logger.Info().Str("post_id", postID).Str("user_id", userID.Hex()).Msg("Post liked successfully")
+	if _, err := m.users.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$pull": bson.M{"liked_posts": postID}}); err != nil {
+		m.logger.Error().Err(err).Str("post_id", postID).Str("user_id", userID.Hex()).Msg("Failed to update user")
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+
+	notification := bson.M{"type": models.NotificationTypeLike, "post_id": postObjectID, "actor_id": userID}
+	if _, err := m.notifications.DeleteMany(ctx, notification); err != nil {
+		m.logger.Error().Err(err).Str("post_id", postID).Str("user_id", userID.Hex()).Msg("Failed to delete notification")
+		return fmt.Errorf("failed to delete notification: %w", err)
+	}
+
+	m.logger.Info().Str("post_id", postID).Str("user_id", userID.Hex()).Msg("Post unliked successfully")
 	return nil
 }
 
+// GetPostLikers returns the users who liked a post, most recent first.
+func (m *MongoDB) GetPostLikers(postID primitive.ObjectID, limit, offset int64) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// liked_by is kept in the order of the likes, so the page is cut from
+	// the reversed array.
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"_id": postID}}},
+		{{Key: "$project", Value: bson.M{
+			"liked_by": bson.M{"$slice": bson.A{bson.M{"$reverseArray": bson.M{"$ifNull": bson.A{"$liked_by", bson.A{}}}}, offset, limit}},
+		}}},
+	}
+	cursor, err := m.posts.Aggregate(ctx, pipeline)
+	if err != nil {
+		m.logger.Error().Err(err).Str("post_id", postID.Hex()).Msg("Failed to fetch likes")
+		return nil, err
+	}
+
+	var pages []struct {
+		LikedBy []primitive.ObjectID `bson:"liked_by"`
+	}
+	if err := cursor.All(ctx, &pages); err != nil {
+		m.logger.Error().Err(err).Str("post_id", postID.Hex()).Msg("Failed to decode likes")
+		return nil, err
+	}
+	if len(pages) == 0 {
+		return []models.User{}, nil
+	}
+
+	return m.getUsersByIDs(ctx, pages[0].LikedBy)
+}
+
 func (m *MongoDB) DeletePost(id string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -175,11 +276,23 @@ func (m *MongoDB) DeletePost(id string) error {
 		return err
 	}
 
+	if _, err := m.postRevisions.DeleteMany(ctx, bson.M{"post_id": objectID}); err != nil {
+		m.logger.Error().Err(err).Str("id", id).Msg("Failed to delete post revisions")
+		return err
+	}
+
+	if _, err := m.comments.DeleteMany(ctx, bson.M{"post_id": objectID}); err != nil {
+		m.logger.Error().Err(err).Str("id", id).Msg("Failed to delete post comments")
+		return err
+	}
+
 	_, err = m.posts.DeleteOne(ctx, bson.M{"_id": objectID})
 	return err
 }
 
-func (m *MongoDB) GetPostsByProfile(profileID string) ([]models.Post, error) {
+// GetPostsByProfile returns the posts of profileID, or none if viewerID may
+// not see them, see postVisibility.
+func (m *MongoDB) GetPostsByProfile(profileID string, viewerID primitive.ObjectID) ([]models.Post, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -188,7 +301,12 @@ func (m *MongoDB) GetPostsByProfile(profileID string) ([]models.Post, error) {
 		return nil, err
 	}
 
-	cursor, err := m.posts.Find(ctx, bson.M{"author_id": objectID})
+	visible, err := m.postVisibility(ctx, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := m.posts.Find(ctx, bson.M{"$and": bson.A{visible, bson.M{"author_id": objectID}}})
 	if err != nil {
 		return nil, err
 	}
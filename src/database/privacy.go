@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"glamapp/src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrPrivate is returned when a post of a private account is accessed by
+// someone who is not an approved follower.
+var ErrPrivate = errors.New("account is private")
+
+// postVisibility returns the filter for the posts viewerID may see: none by
+// hidden or deactivated users, and those of private accounts only if
+// viewerID is the author or an approved follower.
+func (m *MongoDB) postVisibility(ctx context.Context, viewerID primitive.ObjectID) (bson.M, error) {
+	hidden, err := m.hiddenUserIDs(ctx, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	following, err := m.followingIDs(ctx, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return bson.M{
+		"author_id":          bson.M{"$nin": hidden},
+		"author_deactivated": bson.M{"$ne": true},
+		"$or": bson.A{
+			bson.M{"author_private": bson.M{"$ne": true}},
+			bson.M{"author_id": bson.M{"$in": append(following, viewerID)}},
+		},
+	}, nil
+}
+
+// CanSeePrivatePost reports whether viewerID may see a post of a private
+// account. Posts of public accounts are always allowed.
+func (m *MongoDB) CanSeePrivatePost(viewerID primitive.ObjectID, post *models.Post) (bool, error) {
+	if !post.AuthorPrivate || post.AuthorID == viewerID {
+		return true, nil
+	}
+	return m.IsFollowing(viewerID, post.AuthorID)
+}
+
+// applyPrivacy updates the posts of a user that changed the private flag.
+// Making an account public accepts all pending follow requests.
+func (m *MongoDB) applyPrivacy(ctx context.Context, userID primitive.ObjectID, private bool) error {
+	update := bson.M{"$set": bson.M{"author_private": true}}
+	if !private {
+		update = bson.M{"$unset": bson.M{"author_private": ""}}
+	}
+	if _, err := m.posts.UpdateMany(ctx, bson.M{"author_id": userID}, update); err != nil {
+		m.logger.Error().Err(err).Str("user_id", userID.Hex()).Msg("Failed to update post privacy")
+		return fmt.Errorf("failed to update posts: %w", err)
+	}
+
+	if private {
+		return nil
+	}
+	if err := m.acceptFollowRequests(ctx, userID); err != nil {
+		m.logger.Error().Err(err).Str("user_id", userID.Hex()).Msg("Failed to accept follow requests")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"glamapp/src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrBlocked is returned for interactions between users where one blocked
+// the other.
+var ErrBlocked = errors.New("user is blocked")
+
+// Restrict blocks or mutes targetID for userID and reports false if it was
+// already in place. Blocking also removes follows in both directions.
+func (m *MongoDB) Restrict(userID, targetID primitive.ObjectID, kind models.RestrictionKind) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	restriction := models.Restriction{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		TargetID:  targetID,
+		Kind:      kind,
+		CreatedAt: time.Now(),
+	}
+	if _, err := m.restrictions.InsertOne(ctx, restriction); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		m.logger.Error().Err(err).Str("user_id", userID.Hex()).Str("target_id", targetID.Hex()).Str("kind", string(kind)).Msg("Failed to insert restriction")
+		return false, fmt.Errorf("failed to insert restriction: %w", err)
+	}
+
+	if kind == models.RestrictionBlock {
+		if _, err := m.Unfollow(userID, targetID); err != nil {
+			return true, err
+		}
+		if _, err := m.Unfollow(targetID, userID); err != nil {
+			return true, err
+		}
+	}
+
+	return true, nil
+}
+
+// Unrestrict lifts a block or mute and reports false if there was none.
+func (m *MongoDB) Unrestrict(userID, targetID primitive.ObjectID, kind models.RestrictionKind) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := m.restrictions.DeleteOne(ctx, bson.M{"user_id": userID, "target_id": targetID, "kind": kind})
+	if err != nil {
+		m.logger.Error().Err(err).Str("user_id", userID.Hex()).Str("target_id", targetID.Hex()).Str("kind", string(kind)).Msg("Failed to delete restriction")
+		return false, fmt.Errorf("failed to delete restriction: %w", err)
+	}
+	return result.DeletedCount == 1, nil
+}
+
+// GetHiddenUserIDs returns the users whose content userID must not see:
+// everyone they blocked or muted and everyone who blocked them.
+func (m *MongoDB) GetHiddenUserIDs(userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return m.hiddenUserIDs(ctx, userID)
+}
+
+func (m *MongoDB) hiddenUserIDs(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"user_id": userID},
+		bson.M{"target_id": userID, "kind": models.RestrictionBlock},
+	}}
+	cursor, err := m.restrictions.Find(ctx, filter)
+	if err != nil {
+		m.logger.Error().Err(err).Str("user_id", userID.Hex()).Msg("Failed to fetch restrictions")
+		return nil, err
+	}
+
+	var restrictions []models.Restriction
+	if err := cursor.All(ctx, &restrictions); err != nil {
+		m.logger.Error().Err(err).Str("user_id", userID.Hex()).Msg("Failed to decode restrictions")
+		return nil, err
+	}
+
+	ids := []primitive.ObjectID{}
+	for _, restriction := range restrictions {
+		if restriction.UserID == userID {
+			ids = append(ids, restriction.TargetID)
+		} else {
+			ids = append(ids, restriction.UserID)
+		}
+	}
+	return ids, nil
+}
+
+// IsBlocked reports whether either user blocked the other.
+func (m *MongoDB) IsBlocked(a, b primitive.ObjectID) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return m.isBlocked(ctx, a, b)
+}
+
+func (m *MongoDB) isBlocked(ctx context.Context, a, b primitive.ObjectID) (bool, error) {
+	filter := bson.M{"kind": models.RestrictionBlock, "$or": bson.A{
+		bson.M{"user_id": a, "target_id": b},
+		bson.M{"user_id": b, "target_id": a},
+	}}
+	count, err := m.restrictions.CountDocuments(ctx, filter)
+	if err != nil {
+		m.logger.Error().Err(err).Str("user_id", a.Hex()).Str("target_id", b.Hex()).Msg("Failed to check block")
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// notify stores a notification unless its recipient blocked or muted the
+// user who caused it.
+func (m *MongoDB) notify(ctx context.Context, notification *models.Notification) error {
+	count, err := m.restrictions.CountDocuments(ctx, bson.M{"user_id": notification.LikedBy, "target_id": notification.ActorID})
+	if err != nil {
+		return fmt.Errorf("failed to check restrictions: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	if _, err := m.notifications.InsertOne(ctx, notification); err != nil {
+		return fmt.Errorf("failed to insert notification: %w", err)
+	}
+	return nil
+}
+
+// deleteUserRestrictions removes blocks and mutes made by or against a
+// deleted user.
+func (m *MongoDB) deleteUserRestrictions(ctx context.Context, userID primitive.ObjectID) error {
+	filter := bson.M{"$or": bson.A{bson.M{"user_id": userID}, bson.M{"target_id": userID}}}
+	if _, err := m.restrictions.DeleteMany(ctx, filter); err != nil {
+		return fmt.Errorf("failed to delete restrictions: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package database
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"glamapp/src/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Search ranks, lower is better.
+const (
+	searchRankExact = iota
+	searchRankNamePrefix
+	searchRankDisplayNamePrefix
+	searchRankFuzzy
+)
+
+// fuzzyPrefixLength is how many leading characters a fuzzy match has to
+// share with the name, so the search stays within an index range.
+const fuzzyPrefixLength = 2
+
+// SearchUsers finds users whose name or a word of their display name starts
+// with query, falling back to names that start like query and contain the
+// rest of its characters in order. Exact name matches come first, then name
+// prefixes, display name prefixes and fuzzy matches, more followed users
+// first within each rank. Users hidden from viewerID are left out.
+func (m *MongoDB) SearchUsers(query string, viewerID primitive.ObjectID, limit, offset int64) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	hidden, err := m.hiddenUserIDs(ctx, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Matching runs on the lowercase search keys with anchored,
+	// case-sensitive patterns so it can use their indexes.
+	lower := strings.Join(strings.Fields(strings.ToLower(query)), " ")
+	prefix := "^" + regexp.QuoteMeta(lower)
+	fuzzy := fuzzyPattern(lower)
+
+	regexMatch := func(input interface{}, pattern string) bson.M {
+		return bson.M{"$regexMatch": bson.M{"input": input, "regex": pattern}}
+	}
+	displayNamePrefix := bson.M{"$anyElementTrue": bson.A{bson.M{"$map": bson.M{
+		"input": bson.M{"$ifNull": bson.A{"$display_name_words", bson.A{}}},
+		"in":    regexMatch("$$this", prefix),
+	}}}}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"_id":            bson.M{"$nin": hidden},
+			"deactivated_at": bson.M{"$exists": false},
+			"$or": bson.A{
+				bson.M{"name_lower": primitive.Regex{Pattern: fuzzy}},
+				bson.M{"display_name_words": primitive.Regex{Pattern: prefix}},
+			},
+		}}},
+		{{Key: "$addFields", Value: bson.M{"search_rank": bson.M{"$switch": bson.M{
+			"branches": bson.A{
+				bson.M{"case": bson.M{"$eq": bson.A{"$name_lower", lower}}, "then": searchRankExact},
+				bson.M{"case": regexMatch(bson.M{"$ifNull": bson.A{"$name_lower", ""}}, prefix), "then": searchRankNamePrefix},
+				bson.M{"case": displayNamePrefix, "then": searchRankDisplayNamePrefix},
+			},
+			"default": searchRankFuzzy,
+		}}}}},
+		{{Key: "$sort", Value: bson.D{
+			{Key: "search_rank", Value: 1},
+			{Key: "followers_count", Value: -1},
+			{Key: "name", Value: 1},
+		}}},
+		{{Key: "$skip", Value: offset}},
+		{{Key: "$limit", Value: limit}},
+	}
+
+	cursor, err := m.users.Aggregate(ctx, pipeline)
+	if err != nil {
+		m.logger.Error().Err(err).Str("query", query).Msg("Failed to search users")
+		return nil, err
+	}
+
+	users := []models.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		m.logger.Error().Err(err).Str("query", query).Msg("Failed to decode users")
+		return nil, err
+	}
+	return users, nil
+}
+
+// fuzzyPattern matches names that start with the first fuzzyPrefixLength
+// characters of query and contain the rest in order, so "ghn" finds
+// "ghennadi". The literal prefix bounds the index scan.
+func fuzzyPattern(query string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i, r := range []rune(query) {
+		if i >= fuzzyPrefixLength {
+			b.WriteString(".*")
+		}
+		b.WriteString(regexp.QuoteMeta(string(r)))
+	}
+	return b.String()
+}
+
+// BackfillSearchKeys sets the search keys of users created before they were
+// stored. It is safe to run on every start and returns the number of users
+// updated.
+func (m *MongoDB) BackfillSearchKeys() (int, error) {
+	ctx := context.Background()
+
+	projection := bson.M{"name": 1, "display_name": 1}
+	cursor, err := m.users.Find(ctx, bson.M{"name_lower": bson.M{"$exists": false}}, options.Find().SetProjection(projection))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	updated := 0
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return updated, err
+		}
+		user.SetSearchKeys()
+		// A rename in the meantime has already set the keys.
+		filter := bson.M{"_id": user.ID, "name_lower": bson.M{"$exists": false}}
+		update := bson.M{"$set": bson.M{"name_lower": user.NameLower, "display_name_words": user.DisplayNameWords}}
+		if _, err := m.users.UpdateOne(ctx, filter, update); err != nil {
+			m.logger.Error().Err(err).Str("user_id", user.ID.Hex()).Msg("Failed to set search keys")
+			continue
+		}
+		updated++
+	}
+	return updated, cursor.Err()
+}
@@ -0,0 +1,52 @@
+package database
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestFuzzyPattern(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{"g", `^g`},
+		{"gh", `^gh`},
+		{"ghn", `^gh.*n`},
+		{"ghen", `^gh.*e.*n`},
+		{"a.b", `^a\..*b`},
+		{"(*", `^\(\*`},
+		{"a+b?c", `^a\+.*b.*\?.*c`},
+		{"[x]$", `^\[x.*\].*\$`},
+		{"ée", `^ée`},
+	}
+
+	for _, tt := range tests {
+		if got := fuzzyPattern(tt.query); got != tt.want {
+			t.Errorf("fuzzyPattern(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestFuzzyPatternMatches(t *testing.T) {
+	tests := []struct {
+		query, name string
+		match       bool
+	}{
+		{"ghn", "ghennadi", true},
+		{"ghen", "ghennadi", true},
+		{"gnd", "ghennadi", false},
+		{"hen", "ghennadi", false},
+		// Metacharacters only match themselves.
+		{"a.c", "abc", false},
+		{"a.c", "a.xc", true},
+		{"a.*", "abc", false},
+		{"a|b", "b", false},
+	}
+
+	for _, tt := range tests {
+		re := regexp.MustCompile(fuzzyPattern(tt.query))
+		if got := re.MatchString(tt.name); got != tt.match {
+			t.Errorf("fuzzyPattern(%q) matching %q = %v, want %v", tt.query, tt.name, got, tt.match)
+		}
+	}
+}
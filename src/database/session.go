@@ -8,16 +8,27 @@ import (
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func (m *MongoDB) CreateSession(userID primitive.ObjectID, token string, expiresAt time.Time) (*models.Session, error) {
+// maxPreviousTokens is how many replaced refresh tokens a session remembers
+// to detect reuse. Older ones are forgotten, so long-lived sessions do not
+// grow with every refresh.
+const maxPreviousTokens = 20
+
+func (m *MongoDB) CreateSession(userID primitive.ObjectID, token string, expiresAt time.Time, userAgent, ip string) (*models.Session, error) {
 	session := &models.Session{
-		ID:           primitive.NewObjectID(),
-		UserID:       userID,
-		Token:        token,
-		CreatedAt:    time.Now(),
-		ExpiresAt:    expiresAt,
-		LastActivity: time.Now(),
+		ID:             primitive.NewObjectID(),
+		UserID:         userID,
+		Token:          token,
+		PreviousTokens: []string{},
+		UserAgent:      userAgent,
+		IP:             ip,
+		DeviceName:     models.DeviceName(userAgent),
+		CreatedAt:      time.Now(),
+		ExpiresAt:      expiresAt,
+		LastActivity:   time.Now(),
 	}
 
 	_, err := m.sessions.InsertOne(context.Background(), session)
@@ -39,6 +50,79 @@ func (m *MongoDB) GetSessionByToken(token string) (*models.Session, error) {
 	return &session, nil
 }
 
+func (m *MongoDB) GetSessionByID(sessionID primitive.ObjectID) (*models.Session, error) {
+	var session models.Session
+	err := m.sessions.FindOne(context.Background(), bson.M{"_id": sessionID}).Decode(&session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (m *MongoDB) GetSessionByPreviousToken(token string) (*models.Session, error) {
+	var session models.Session
+	err := m.sessions.FindOne(context.Background(), bson.M{"previous_tokens": token}).Decode(&session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (m *MongoDB) GetUserSessions(userID primitive.ObjectID) ([]models.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "last_activity", Value: -1}})
+	cursor, err := m.sessions.Find(ctx, bson.M{"user_id": userID, "expires_at": bson.M{"$gt": time.Now()}}, opts)
+	if err != nil {
+		m.logger.Error().Err(err).Str("user_id", userID.Hex()).Msg("Failed to fetch sessions")
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sessions := []models.Session{}
+	if err = cursor.All(ctx, &sessions); err != nil {
+		m.logger.Error().Err(err).Str("user_id", userID.Hex()).Msg("Failed to decode sessions")
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// RotateSessionToken replaces the current refresh token of a session. The
+// update only matches while oldToken is still current, so two concurrent
+// refreshes with the same token cannot both succeed.
+func (m *MongoDB) RotateSessionToken(sessionID primitive.ObjectID, oldToken, newToken string, expiresAt time.Time, ip string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := m.sessions.UpdateOne(
+		ctx,
+		bson.M{"_id": sessionID, "token": oldToken},
+		bson.M{
+			"$set": bson.M{
+				"token":         newToken,
+				"expires_at":    expiresAt,
+				"ip":            ip,
+				"last_activity": time.Now(),
+			},
+			"$push": bson.M{"previous_tokens": bson.M{
+				"$each":  bson.A{oldToken},
+				"$slice": -maxPreviousTokens,
+			}},
+		},
+	)
+	if err != nil {
+		m.logger.Error().Err(err).Str("session_id", sessionID.Hex()).Msg("Failed to rotate session token")
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
 func (m *MongoDB) UpdateSessionActivity(sessionID primitive.ObjectID) error {
 	_, err := m.sessions.UpdateOne(
 		context.Background(),
@@ -53,6 +137,49 @@ func (m *MongoDB) DeleteSession(sessionID primitive.ObjectID) error {
 	return err
 }
 
+// DeleteUserSession deletes a session only if it belongs to userID, so users
+// cannot revoke each other's sessions by guessing IDs.
+func (m *MongoDB) DeleteUserSession(userID, sessionID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := m.sessions.DeleteOne(ctx, bson.M{"_id": sessionID, "user_id": userID})
+	if err != nil {
+		m.logger.Error().Err(err).Str("session_id", sessionID.Hex()).Msg("Failed to delete session")
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (m *MongoDB) DeleteUserSessions(userID primitive.ObjectID) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := m.sessions.DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		m.logger.Error().Err(err).Str("user_id", userID.Hex()).Msg("Failed to delete user sessions")
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// DeleteOtherUserSessions revokes every session of the user except keep, e.g.
+// after a password change made from the session being kept.
+func (m *MongoDB) DeleteOtherUserSessions(userID, keep primitive.ObjectID) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := m.sessions.DeleteMany(ctx, bson.M{"user_id": userID, "_id": bson.M{"$ne": keep}})
+	if err != nil {
+		m.logger.Error().Err(err).Str("user_id", userID.Hex()).Msg("Failed to delete other user sessions")
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
 func (m *MongoDB) LogHistory(userID primitive.ObjectID) error {
 	history := &models.History{
 		ID:        primitive.NewObjectID(),
@@ -8,18 +8,26 @@ import (
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// nameCollation compares names case-insensitively. It has to match the
+// collation of the unique index on users.name for lookups to use it.
+var nameCollation = &options.Collation{Locale: "en", Strength: 2}
+
 func (m *MongoDB) CreateUser(user *models.User) error {
 	user.CreatedAt = time.Now()
 	user.UpdatedAt = time.Now()
+	user.SetSearchKeys()
 	_, err := m.users.InsertOne(context.Background(), user)
 	return err
 }
 
 func (m *MongoDB) GetUserByName(name string) (*models.User, error) {
 	var user models.User
-	err := m.users.FindOne(context.Background(), bson.M{"name": name}).Decode(&user)
+	opts := options.FindOne().SetCollation(nameCollation)
+	err := m.users.FindOne(context.Background(), bson.M{"name": name}, opts).Decode(&user)
 	if err != nil {
 		m.logger.Error().Err(err).Msg("Failed to get user by name")
 		return nil, err
@@ -47,19 +55,44 @@ func (m *MongoDB) UpdateUser(id string, updateData *models.User, updatedFields m
 		return err
 	}
 
+	updateData.SetSearchKeys()
 	updateFields := bson.M{}
 	if updatedFields["name"] {
 		updateFields["name"] = updateData.Name
+		updateFields["name_lower"] = updateData.NameLower
+	}
+	if updatedFields["email"] {
+		updateFields["email"] = updateData.Email
 	}
 	if updatedFields["password_hash"] {
 		updateFields["password_hash"] = updateData.PasswordHash
 	}
 
-	if updatedFields["avatar_data"] {
-		updateFields["avatar_data"] = updateData.AvatarData
+	if updatedFields["display_name"] {
+		updateFields["display_name"] = updateData.DisplayName
+		updateFields["display_name_words"] = updateData.DisplayNameWords
+	}
+	if updatedFields["bio"] {
+		updateFields["bio"] = updateData.Bio
+	}
+	if updatedFields["website"] {
+		updateFields["website"] = updateData.Website
 	}
-	if updatedFields["avatar_type"] {
-		updateFields["avatar_type"] = updateData.AvatarType
+	if updatedFields["pronouns"] {
+		updateFields["pronouns"] = updateData.Pronouns
+	}
+	if updatedFields["location"] {
+		updateFields["location"] = updateData.Location
+	}
+	if updatedFields["links"] {
+		updateFields["links"] = updateData.Links
+	}
+	if updatedFields["private"] {
+		updateFields["private"] = updateData.Private
+	}
+
+	if updatedFields["avatar"] {
+		updateFields["avatar"] = updateData.Avatar
 	}
 	updateFields["updated_at"] = time.Now()
 
@@ -72,8 +105,14 @@ func (m *MongoDB) UpdateUser(id string, updateData *models.User, updatedFields m
 		bson.M{"_id": objectID},
 		update,
 	)
+	if err != nil {
+		return err
+	}
 
-	return err
+	if updatedFields["private"] {
+		return m.applyPrivacy(ctx, objectID, updateData.Private)
+	}
+	return nil
 }
 
 func (m *MongoDB) GetUser(id string) (*models.User, error) {
@@ -100,7 +139,7 @@ func (m *MongoDB) GetUsers() ([]models.User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	cursor, err := m.users.Find(ctx, bson.M{})
+	cursor, err := m.users.Find(ctx, bson.M{"deactivated_at": bson.M{"$exists": false}})
 	if err != nil {
 		m.logger.Error().Err(err).Msg("Failed to fetch users")
 		return nil, err
@@ -116,21 +155,107 @@ func (m *MongoDB) GetUsers() ([]models.User, error) {
 	return users, nil
 }
 
-func (m *MongoDB) DeleteUser(id string) error {
+func (m *MongoDB) SetUserRole(id primitive.ObjectID, role models.Role) error {
+	return m.setUserRole(bson.M{"_id": id}, role)
+}
+
+func (m *MongoDB) SetUserRoleByName(name string, role models.Role) error {
+	return m.setUserRole(bson.M{"name": name}, role)
+}
+
+func (m *MongoDB) setUserRole(filter bson.M, role models.Role) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	objectID, err := primitive.ObjectIDFromHex(id)
+	opts := options.Update().SetCollation(nameCollation)
+	result, err := m.users.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"role": role, "updated_at": time.Now()}}, opts)
 	if err != nil {
-		m.logger.Error().Err(err).Msg("Failed to convert ID to ObjectID")
+		m.logger.Error().Err(err).Msg("Failed to set user role")
 		return err
 	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
 
-	_, err = m.users.DeleteOne(ctx, bson.M{"_id": objectID})
+	return nil
+}
+
+// SetTOTPSecret stores a pending TOTP secret and resets the last used step.
+// Two-factor authentication only becomes active once EnableTOTP is called
+// after the first valid code.
+func (m *MongoDB) SetTOTPSecret(id primitive.ObjectID, secret string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := m.users.UpdateOne(ctx, bson.M{"_id": id, "totp_enabled": bson.M{"$ne": true}}, bson.M{
+		"$set": bson.M{"totp_secret": secret, "totp_enabled": false, "totp_last_step": 0, "updated_at": time.Now()},
+	})
 	if err != nil {
-		m.logger.Error().Err(err).Msg("Failed to delete user")
-		return err
+		m.logger.Error().Err(err).Str("user_id", id.Hex()).Msg("Failed to set TOTP secret")
+	}
+	return err
+}
+
+func (m *MongoDB) EnableTOTP(id primitive.ObjectID, recoveryCodes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := m.users.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": bson.M{"totp_enabled": true, "recovery_codes": recoveryCodes, "updated_at": time.Now()},
+	})
+	if err != nil {
+		m.logger.Error().Err(err).Str("user_id", id.Hex()).Msg("Failed to enable TOTP")
 	}
+	return err
+}
 
-	return nil
+func (m *MongoDB) DisableTOTP(id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := m.users.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set":   bson.M{"totp_enabled": false, "totp_last_step": 0, "updated_at": time.Now()},
+		"$unset": bson.M{"totp_secret": "", "recovery_codes": ""},
+	})
+	if err != nil {
+		m.logger.Error().Err(err).Str("user_id", id.Hex()).Msg("Failed to disable TOTP")
+	}
+	return err
+}
+
+// UseTOTPStep records the time step of an accepted code. It returns false if
+// a code from the same or a later step was already used. Users that never
+// used a code may not have totp_last_step yet.
+func (m *MongoDB) UseTOTPStep(id primitive.ObjectID, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": id, "$or": bson.A{
+		bson.M{"totp_last_step": bson.M{"$lt": step}},
+		bson.M{"totp_last_step": bson.M{"$exists": false}},
+	}}
+	result, err := m.users.UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{"totp_last_step": step},
+	})
+	if err != nil {
+		m.logger.Error().Err(err).Str("user_id", id.Hex()).Msg("Failed to record TOTP step")
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// UseRecoveryCode removes a hashed recovery code, returning false if the user
+// does not have it.
+func (m *MongoDB) UseRecoveryCode(id primitive.ObjectID, codeHash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := m.users.UpdateOne(ctx, bson.M{"_id": id, "recovery_codes": codeHash}, bson.M{
+		"$pull": bson.M{"recovery_codes": codeHash},
+	})
+	if err != nil {
+		m.logger.Error().Err(err).Str("user_id", id.Hex()).Msg("Failed to use recovery code")
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
 }
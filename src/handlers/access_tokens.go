@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"errors"
+	"glamapp/src/database"
+	"glamapp/src/models"
+	"glamapp/src/tokens"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	maxAccessTokenNameLength = 100
+	accessTokenPrefixLength  = len(tokens.PersonalPrefix) + 4
+)
+
+type AccessTokenHandler struct {
+	DB     *database.MongoDB
+	Logger zerolog.Logger
+}
+
+func NewAccessTokenHandler(db *database.MongoDB, logger zerolog.Logger) *AccessTokenHandler {
+	return &AccessTokenHandler{
+		DB:     db,
+		Logger: logger,
+	}
+}
+
+func (h *AccessTokenHandler) CreateAccessToken(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	var tokenData struct {
+		Name          string         `json:"name"`
+		Scopes        []models.Scope `json:"scopes"`
+		ExpiresInDays int            `json:"expires_in_days"`
+	}
+	if err := c.BodyParser(&tokenData); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	tokenData.Name = strings.TrimSpace(tokenData.Name)
+	if tokenData.Name == "" || len(tokenData.Name) > maxAccessTokenNameLength {
+		return fiber.NewError(fiber.StatusBadRequest, "name is required and must be at most 100 characters")
+	}
+	if len(tokenData.Scopes) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "at least one scope is required")
+	}
+	for _, scope := range tokenData.Scopes {
+		if !scope.Valid() {
+			return fiber.NewError(fiber.StatusBadRequest, "unknown scope "+string(scope))
+		}
+	}
+	if tokenData.ExpiresInDays < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "expires_in_days must not be negative")
+	}
+
+	secret, err := tokens.GeneratePersonal()
+	if err != nil {
+		h.Logger.Error().Err(err).Msg("Failed to generate access token")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to create access token")
+	}
+
+	token := &models.AccessToken{
+		UserID:    user.ID,
+		Name:      tokenData.Name,
+		Prefix:    secret[:accessTokenPrefixLength],
+		TokenHash: tokens.Hash(secret),
+		Scopes:    tokenData.Scopes,
+	}
+	if tokenData.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, tokenData.ExpiresInDays)
+		token.ExpiresAt = &expiresAt
+	}
+
+	if err := h.DB.CreateAccessToken(token); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to create access token")
+	}
+
+	h.Logger.Info().Str("token_id", token.ID.Hex()).Str("user_id", user.ID.Hex()).Msg("Access token created")
+
+	response := token.ToResponse()
+	response["token"] = secret
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+func (h *AccessTokenHandler) GetAccessTokens(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	accessTokens, err := h.DB.GetUserAccessTokens(user.ID)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to fetch access tokens")
+	}
+
+	responseTokens := []map[string]interface{}{}
+	for _, token := range accessTokens {
+		responseTokens = append(responseTokens, token.ToResponse())
+	}
+
+	return c.JSON(fiber.Map{
+		"data":  responseTokens,
+		"count": len(responseTokens),
+	})
+}
+
+func (h *AccessTokenHandler) DeleteAccessToken(c *fiber.Ctx) error {
+	id := c.Params("id")
+	user := c.Locals("user").(*models.User)
+
+	tokenID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid token ID")
+	}
+
+	if err := h.DB.DeleteUserAccessToken(user.ID, tokenID); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return fiber.NewError(fiber.StatusNotFound, "Access token not found")
+		}
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to delete access token")
+	}
+
+	h.Logger.Info().Str("token_id", id).Str("user_id", user.ID.Hex()).Msg("Access token revoked")
+	return c.JSON(fiber.Map{
+		"detail": "Access token revoked successfully",
+	})
+}
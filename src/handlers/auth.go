@@ -1,26 +1,60 @@
 package handlers
 
 import (
+	"errors"
 	"glamapp/src/database"
 	"glamapp/src/models"
+	"glamapp/src/tokens"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+const mfaTokenTTL = 5 * time.Minute
+
+// dummyUser is checked against passwords given for unknown names, so a login
+// takes as long whether or not the name exists.
+var (
+	dummyUser     *models.User
+	dummyUserOnce sync.Once
+)
+
+func checkDummyPassword(password string) {
+	dummyUserOnce.Do(func() {
+		dummyUser = models.NewUser()
+		if err := dummyUser.SetPassword("glamapp-dummy-password"); err != nil {
+			panic(err)
+		}
+	})
+	dummyUser.CheckPassword(password)
+}
+
 type AuthHandler struct {
-	DB        *database.MongoDB
-	Logger    zerolog.Logger
-	JWTSecret string
+	DB              *database.MongoDB
+	Logger          zerolog.Logger
+	Keys            *tokens.KeySet
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	AccountLockout  database.LockoutPolicy
+	IPLockout       database.LockoutPolicy
 }
 
-func NewAuthHandler(db *database.MongoDB, logger zerolog.Logger, jwtSecret string) *AuthHandler {
+func NewAuthHandler(db *database.MongoDB, logger zerolog.Logger, keys *tokens.KeySet, accessTokenTTL, refreshTokenTTL time.Duration, accountLockout, ipLockout database.LockoutPolicy) *AuthHandler {
 	return &AuthHandler{
-		DB:        db,
-		Logger:    logger,
-		JWTSecret: jwtSecret,
+		DB:              db,
+		Logger:          logger,
+		Keys:            keys,
+		AccessTokenTTL:  accessTokenTTL,
+		RefreshTokenTTL: refreshTokenTTL,
+		AccountLockout:  accountLockout,
+		IPLockout:       ipLockout,
 	}
 }
 
@@ -34,6 +68,9 @@ func (h *AuthHandler) Register(c *fiber.Ctx) error {
 	}
 
 	if err := h.DB.CreateUser(user); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Name is already taken"})
+		}
 		h.Logger.Error().Err(err).Msg("Failed to create user")
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create user"})
 	}
@@ -43,8 +80,9 @@ func (h *AuthHandler) Register(c *fiber.Ctx) error {
 
 func (h *AuthHandler) Login(c *fiber.Ctx) error {
 	var loginData struct {
-		Name     string `json:"name"`
-		Password string `json:"password"`
+		Name       string `json:"name"`
+		Password   string `json:"password"`
+		Reactivate bool   `json:"reactivate"`
 	}
 
 	if err := c.BodyParser(&loginData); err != nil {
@@ -52,28 +90,310 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
 	}
 
+	accountKey, ipKey := loginAccountKey(loginData.Name), loginIPKey(c)
+	if locked, err := h.checkLockout(c, accountKey, ipKey); locked || err != nil {
+		return err
+	}
+
 	user, err := h.DB.GetUserByName(loginData.Name)
 	if err != nil {
 		h.Logger.Error().Err(err).Msg("Failed to get user by name")
+		checkDummyPassword(loginData.Password)
+		h.recordLoginFailure(accountKey, ipKey)
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid credentials"})
 	}
 
 	if !user.CheckPassword(loginData.Password) {
 		h.Logger.Error().Err(err).Msg("Invalid password")
+		h.recordLoginFailure(accountKey, ipKey)
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid credentials"})
 	}
 
-	token := jwt.New(jwt.SigningMethodHS256)
+	if user.PasswordNeedsRehash() {
+		h.rehashPassword(user, loginData.Password)
+	}
+
+	if refused, err := h.refuseDeactivated(c, user, loginData.Reactivate); refused {
+		return err
+	}
+
+	if user.TOTPEnabled {
+		return h.sendMFAToken(c, user)
+	}
+
+	h.resetLoginFailures(accountKey)
+	return h.startSession(c, user, loginData.Reactivate)
+}
+
+// rehashPassword upgrades a legacy hash with the password that was just
+// verified. Failures are only logged, the old hash keeps working.
+func (h *AuthHandler) rehashPassword(user *models.User, password string) {
+	if err := user.SetPassword(password); err != nil {
+		h.Logger.Error().Err(err).Str("user_id", user.ID.Hex()).Msg("Failed to rehash password")
+		return
+	}
+	if err := h.DB.UpdateUser(user.ID.Hex(), user, map[string]bool{"password_hash": true}); err != nil {
+		h.Logger.Error().Err(err).Str("user_id", user.ID.Hex()).Msg("Failed to store rehashed password")
+		return
+	}
+	h.Logger.Info().Str("user_id", user.ID.Hex()).Msg("Upgraded password hash")
+}
+
+func (h *AuthHandler) LoginMFA(c *fiber.Ctx) error {
+	var mfaData struct {
+		MFAToken   string `json:"mfa_token"`
+		Code       string `json:"code"`
+		Reactivate bool   `json:"reactivate"`
+	}
+
+	if err := c.BodyParser(&mfaData); err != nil {
+		h.Logger.Error().Err(err).Msg("Failed to parse MFA data")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	claims, err := h.Keys.Verify(mfaData.MFAToken, tokens.TypeMFA)
+	if err != nil {
+		h.Logger.Warn().Err(err).Msg("Invalid MFA token")
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired MFA token"})
+	}
+
+	userID := claims.UserID
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired MFA token"})
+	}
+
+	user, err := h.DB.GetUserByID(objectID)
+	if err != nil || !user.TOTPEnabled {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired MFA token"})
+	}
+
+	accountKey, ipKey := loginAccountKey(user.Name), loginIPKey(c)
+	if locked, err := h.checkLockout(c, accountKey, ipKey); locked || err != nil {
+		return err
+	}
+
+	if !verifySecondFactor(h.DB, user, mfaData.Code) {
+		h.Logger.Warn().Str("user_id", userID).Msg("Invalid second factor")
+		h.recordLoginFailure(accountKey, ipKey)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid code"})
+	}
+
+	h.resetLoginFailures(accountKey)
+	return h.startSession(c, user, mfaData.Reactivate)
+}
+
+func loginAccountKey(name string) string {
+	return "name:" + strings.ToLower(name)
+}
+
+func loginIPKey(c *fiber.Ctx) string {
+	return "ip:" + c.IP()
+}
+
+// checkLockout answers with 429 and a Retry-After header when the account or
+// the client IP is locked. It reports whether a response was sent.
+func (h *AuthHandler) checkLockout(c *fiber.Ctx, accountKey, ipKey string) (bool, error) {
+	lockedUntil, err := h.DB.GetLoginLockout(accountKey, ipKey)
+	if err != nil {
+		return true, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not login"})
+	}
+	if lockedUntil.IsZero() {
+		return false, nil
+	}
+
+	retryAfter := int(math.Ceil(time.Until(lockedUntil).Seconds()))
+	h.Logger.Warn().Str("account", accountKey).Str("ip", ipKey).Int("retry_after", retryAfter).Msg("Login attempt while locked")
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+	return true, c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"error":       "Too many failed login attempts",
+		"retry_after": retryAfter,
+	})
+}
+
+func (h *AuthHandler) recordLoginFailure(accountKey, ipKey string) {
+	// Errors are logged by the database layer; a failed counter update must
+	// not turn a wrong password into a server error.
+	_ = h.DB.RecordLoginFailure(accountKey, h.AccountLockout)
+	_ = h.DB.RecordLoginFailure(ipKey, h.IPLockout)
+}
+
+func (h *AuthHandler) resetLoginFailures(accountKey string) {
+	_ = h.DB.ResetLoginFailures(accountKey)
+}
+
+// sendMFAToken answers a correct password for an account with two-factor
+// authentication. The returned token only proves the first factor and is
+// exchanged for real tokens on /auth/login/mfa.
+func (h *AuthHandler) sendMFAToken(c *fiber.Ctx, user *models.User) error {
+	t, err := h.Keys.Issue(tokens.TypeMFA, user.ID.Hex(), "", mfaTokenTTL)
+	if err != nil {
+		h.Logger.Error().Err(err).Msg("Failed to generate MFA token")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not login"})
+	}
+
+	return c.JSON(fiber.Map{
+		"mfa_required": true,
+		"mfa_token":    t,
+		"expires_in":   int(mfaTokenTTL.Seconds()),
+	})
+}
+
+// refuseDeactivated answers with 403 when a deactivated account logs in
+// without asking to be reactivated, telling the client how long it can
+// still be restored. It reports whether a response was sent.
+func (h *AuthHandler) refuseDeactivated(c *fiber.Ctx, user *models.User, reactivate bool) (bool, error) {
+	if !user.Deactivated() {
+		return false, nil
+	}
+
+	if user.PurgeAt == nil || time.Now().After(*user.PurgeAt) {
+		return true, c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Account has been deleted"})
+	}
+	if reactivate {
+		return false, nil
+	}
+
+	return true, c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"error":                 "Account is deactivated",
+		"reactivation_required": true,
+		"purge_at":              user.PurgeAt,
+	})
+}
+
+// startSession logs the user in. A deactivated account is restored first if
+// reactivate is set and refused otherwise.
+func (h *AuthHandler) startSession(c *fiber.Ctx, user *models.User, reactivate bool) error {
+	if refused, err := h.refuseDeactivated(c, user, reactivate); refused {
+		return err
+	}
+
+	if user.Deactivated() {
+		reactivated, err := h.DB.ReactivateUser(user.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not login"})
+		}
+		if !reactivated {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Account has been deleted"})
+		}
+		h.Logger.Info().Str("user_id", user.ID.Hex()).Msg("User reactivated")
+	}
+
+	refreshToken, err := tokens.Generate()
+	if err != nil {
+		h.Logger.Error().Err(err).Msg("Failed to generate refresh token")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not login"})
+	}
+
+	session, err := h.DB.CreateSession(user.ID, tokens.Hash(refreshToken), time.Now().Add(h.RefreshTokenTTL), c.Get(fiber.HeaderUserAgent), c.IP())
+	if err != nil {
+		h.Logger.Error().Err(err).Str("user_id", user.ID.Hex()).Msg("Failed to create session")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not login"})
+	}
+
+	return h.sendTokens(c, session, refreshToken)
+}
+
+func (h *AuthHandler) Refresh(c *fiber.Ctx) error {
+	var refreshData struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if err := c.BodyParser(&refreshData); err != nil || refreshData.RefreshToken == "" {
+		h.Logger.Error().Err(err).Msg("Failed to parse refresh data")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	tokenHash := tokens.Hash(refreshData.RefreshToken)
+	session, err := h.DB.GetSessionByToken(tokenHash)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			h.revokeReusedSession(tokenHash)
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid refresh token"})
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		h.Logger.Info().Str("session_id", session.ID.Hex()).Msg("Refresh token expired")
+		if err := h.DB.DeleteSession(session.ID); err != nil {
+			h.Logger.Error().Err(err).Str("session_id", session.ID.Hex()).Msg("Failed to delete expired session")
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid refresh token"})
+	}
+
+	refreshToken, err := tokens.Generate()
+	if err != nil {
+		h.Logger.Error().Err(err).Msg("Failed to generate refresh token")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not refresh token"})
+	}
+
+	err = h.DB.RotateSessionToken(session.ID, tokenHash, tokens.Hash(refreshToken), time.Now().Add(h.RefreshTokenTTL), c.IP())
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// Another request rotated this token first, which is exactly what
+			// a replayed token looks like.
+			h.revokeReusedSession(tokenHash)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid refresh token"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not refresh token"})
+	}
+
+	return h.sendTokens(c, session, refreshToken)
+}
+
+func (h *AuthHandler) Logout(c *fiber.Ctx) error {
+	session := c.Locals("session").(*models.Session)
+
+	if err := h.DB.DeleteSession(session.ID); err != nil {
+		h.Logger.Error().Err(err).Str("session_id", session.ID.Hex()).Msg("Failed to delete session")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not logout"})
+	}
+
+	h.Logger.Info().Str("session_id", session.ID.Hex()).Str("user_id", session.UserID.Hex()).Msg("User logged out")
+	return c.JSON(fiber.Map{"detail": "Logged out successfully"})
+}
+
+func (h *AuthHandler) LogoutAll(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	count, err := h.DB.DeleteUserSessions(user.ID)
+	if err != nil {
+		h.Logger.Error().Err(err).Str("user_id", user.ID.Hex()).Msg("Failed to delete user sessions")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not logout"})
+	}
 
-	claims := token.Claims.(jwt.MapClaims)
-	claims["user_id"] = user.ID
-	claims["exp"] = time.Now().Add(time.Hour * 72).Unix()
+	h.Logger.Info().Str("user_id", user.ID.Hex()).Int64("count", count).Msg("User logged out from all sessions")
+	return c.JSON(fiber.Map{
+		"detail": "Logged out from all sessions",
+		"count":  count,
+	})
+}
+
+// revokeReusedSession deletes the session a rotated refresh token belonged
+// to. A rotated token is only ever presented again if it was stolen, so the
+// legitimate client is logged out too and has to authenticate again.
+func (h *AuthHandler) revokeReusedSession(tokenHash string) {
+	session, err := h.DB.GetSessionByPreviousToken(tokenHash)
+	if err != nil {
+		return
+	}
+
+	h.Logger.Warn().Str("session_id", session.ID.Hex()).Str("user_id", session.UserID.Hex()).Msg("Refresh token reuse detected, revoking session")
+	if err := h.DB.DeleteSession(session.ID); err != nil {
+		h.Logger.Error().Err(err).Str("session_id", session.ID.Hex()).Msg("Failed to revoke session")
+	}
+}
 
-	t, err := token.SignedString([]byte(h.JWTSecret))
+func (h *AuthHandler) sendTokens(c *fiber.Ctx, session *models.Session, refreshToken string) error {
+	t, err := h.Keys.Issue(tokens.TypeAccess, session.UserID.Hex(), session.ID.Hex(), h.AccessTokenTTL)
 	if err != nil {
 		h.Logger.Error().Err(err).Msg("Failed to generate token")
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not login"})
 	}
 
-	return c.JSON(fiber.Map{"token": t})
+	return c.JSON(fiber.Map{
+		"token":         t,
+		"refresh_token": refreshToken,
+		"expires_in":    int(h.AccessTokenTTL.Seconds()),
+	})
 }
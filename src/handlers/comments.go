@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"glamapp/src/database"
+	"glamapp/src/models"
+)
+
+// CreateComment adds a comment to a post, or a reply to the comment in
+// parent_id as long as it stays within CommentMaxDepth.
+func (h *PostHandler) CreateComment(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	post, err := h.visiblePost(c)
+	if err != nil {
+		return err
+	}
+
+	var comment models.Comment
+	if err := comment.Parse(c, post.ID, user.ID); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	var parent *models.Comment
+	if comment.ParentID != nil {
+		parent, err = h.visibleComment(post, *comment.ParentID, user.ID)
+		if err != nil {
+			return err
+		}
+		if parent.Depth >= h.CommentMaxDepth {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Replies can only be nested %d levels deep", h.CommentMaxDepth))
+		}
+		comment.Depth = parent.Depth + 1
+	}
+
+	if err := h.DB.CreateComment(post, parent, &comment); err != nil {
+		if errors.Is(err, database.ErrCommentNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Comment not found")
+		}
+		h.Logger.Error().Err(err).Str("post_id", post.ID.Hex()).Msg("Failed to create comment")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to create comment")
+	}
+
+	h.Logger.Info().Str("post_id", post.ID.Hex()).Str("comment_id", comment.ID.Hex()).Str("user_id", user.ID.Hex()).Msg("Comment created successfully")
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"data": comment,
+	})
+}
+
+// GetComments lists the comments on a post, or with ?parent_id the replies
+// to a comment, oldest first.
+func (h *PostHandler) GetComments(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		return err
+	}
+
+	post, err := h.visiblePost(c)
+	if err != nil {
+		return err
+	}
+
+	var parentID *primitive.ObjectID
+	if value := c.Query("parent_id"); value != "" {
+		id, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid parent_id")
+		}
+		if _, err := h.DB.GetComment(post.ID, id); err != nil {
+			return fiber.NewError(fiber.StatusNotFound, "Comment not found")
+		}
+		parentID = &id
+	}
+
+	comments, err := h.DB.GetComments(post.ID, parentID, user.ID, limit, offset)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to fetch comments")
+	}
+
+	return c.JSON(fiber.Map{
+		"data":   comments,
+		"count":  len(comments),
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// DeleteComment lets the author of a comment, the author of the post and
+// moderators remove a comment. Everyone but moderators has to be able to see
+// the post.
+func (h *PostHandler) DeleteComment(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	var post *models.Post
+	var err error
+	if user.HasRole(models.RoleModerator) {
+		post, err = h.DB.GetPost(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusNotFound, "Post not found")
+		}
+	} else if post, err = h.visiblePost(c); err != nil {
+		return err
+	}
+
+	commentID, err := primitive.ObjectIDFromHex(c.Params("commentId"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid comment ID")
+	}
+	comment, err := h.DB.GetComment(post.ID, commentID)
+	if err != nil || comment.Deleted {
+		return fiber.NewError(fiber.StatusNotFound, "Comment not found")
+	}
+
+	if user.ID != post.AuthorID && !user.CanModify(comment.AuthorID, models.RoleModerator) {
+		h.Logger.Warn().Str("comment_id", commentID.Hex()).Str("user_id", user.ID.Hex()).Msg("Forbidden comment deletion")
+		return fiber.NewError(fiber.StatusForbidden, "You are not allowed to delete this comment")
+	}
+
+	if err := h.DB.DeleteComment(comment); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to delete comment")
+	}
+
+	h.Logger.Info().Str("comment_id", commentID.Hex()).Str("user_id", user.ID.Hex()).Msg("Comment deleted successfully")
+	return c.JSON(fiber.Map{
+		"detail": "Comment deleted successfully",
+	})
+}
+
+func (h *PostHandler) LikeComment(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	post, err := h.visiblePost(c)
+	if err != nil {
+		return err
+	}
+
+	commentID, err := primitive.ObjectIDFromHex(c.Params("commentId"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid comment ID")
+	}
+	comment, err := h.visibleComment(post, commentID, user.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := h.DB.LikeComment(comment, user.ID); err != nil {
+		if errors.Is(err, database.ErrAlreadyLiked) {
+			return fiber.NewError(fiber.StatusBadRequest, "Comment already liked")
+		}
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to like comment")
+	}
+
+	h.Logger.Info().Str("comment_id", commentID.Hex()).Str("user_id", user.ID.Hex()).Msg("Comment liked successfully")
+	return c.JSON(fiber.Map{
+		"detail": "Comment liked successfully",
+	})
+}
+
+func (h *PostHandler) UnlikeComment(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	post, err := h.visiblePost(c)
+	if err != nil {
+		return err
+	}
+	commentID, err := primitive.ObjectIDFromHex(c.Params("commentId"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid comment ID")
+	}
+
+	if err := h.DB.UnlikeComment(post.ID, commentID, user.ID); err != nil {
+		if errors.Is(err, database.ErrNotLiked) {
+			return fiber.NewError(fiber.StatusBadRequest, "Comment not liked or not found")
+		}
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to unlike comment")
+	}
+
+	h.Logger.Info().Str("comment_id", commentID.Hex()).Str("user_id", user.ID.Hex()).Msg("Comment unliked successfully")
+	return c.JSON(fiber.Map{
+		"detail": "Comment unliked successfully",
+	})
+}
+
+// visibleComment fetches a comment of post and answers with 404 when it was
+// deleted or its author is deactivated or blocked with userID.
+func (h *PostHandler) visibleComment(post *models.Post, id, userID primitive.ObjectID) (*models.Comment, error) {
+	comment, err := h.DB.GetComment(post.ID, id)
+	if err != nil || comment.Deleted || comment.AuthorDeactivated {
+		return nil, fiber.NewError(fiber.StatusNotFound, "Comment not found")
+	}
+
+	blocked, err := h.DB.IsBlocked(userID, comment.AuthorID)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "Failed to fetch comment")
+	}
+	if blocked {
+		return nil, fiber.NewError(fiber.StatusNotFound, "Comment not found")
+	}
+
+	return comment, nil
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"glamapp/src/database"
+	"glamapp/src/mailer"
+	"glamapp/src/models"
+	"glamapp/src/tokens"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+)
+
+type EmailChangeHandler struct {
+	DB         *database.MongoDB
+	Logger     zerolog.Logger
+	Sender     mailer.Sender
+	TTL        time.Duration
+	ConfirmURL string
+}
+
+func NewEmailChangeHandler(db *database.MongoDB, logger zerolog.Logger, sender mailer.Sender, ttl time.Duration, confirmURL string) *EmailChangeHandler {
+	return &EmailChangeHandler{
+		DB:         db,
+		Logger:     logger,
+		Sender:     sender,
+		TTL:        ttl,
+		ConfirmURL: confirmURL,
+	}
+}
+
+// ChangeEmail sends a confirmation token to the new address. The email is
+// only changed once the token comes back through ConfirmEmail, so whoever
+// changes it must know the password and own the new mailbox.
+func (h *EmailChangeHandler) ChangeEmail(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	var emailData struct {
+		CurrentPassword string `json:"current_password"`
+		Email           string `json:"email"`
+	}
+	if err := c.BodyParser(&emailData); err != nil || emailData.Email == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "email is required")
+	}
+
+	// Like ChangePassword, users who signed up through an identity provider
+	// have no password to check.
+	if user.PasswordHash != "" && !user.CheckPassword(emailData.CurrentPassword) {
+		h.Logger.Warn().Str("user_id", user.ID.Hex()).Msg("Wrong current password on email change")
+		return fiber.NewError(fiber.StatusUnauthorized, "Current password is incorrect")
+	}
+
+	email, err := models.ParseEmail(emailData.Email)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	token, err := tokens.Generate()
+	if err != nil {
+		h.Logger.Error().Err(err).Msg("Failed to generate email change token")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to change email")
+	}
+
+	expiresAt := time.Now().Add(h.TTL)
+	if err := h.DB.CreateEmailChange(user.ID, email, tokens.Hash(token), expiresAt); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to change email")
+	}
+
+	body := "Use this token to confirm your new glamapp email address: " + token
+	if h.ConfirmURL != "" {
+		body = "Follow this link to confirm your new glamapp email address: " + h.ConfirmURL + "?token=" + token
+	}
+	body += "\n\nIt expires at " + expiresAt.Format(time.RFC1123) + ". If you did not ask for this change, ignore this message."
+
+	err = h.Sender.Send(mailer.Message{
+		To:      email,
+		Subject: "Confirm your new glamapp email address",
+		Body:    body,
+	})
+	if err != nil {
+		h.Logger.Error().Err(err).Str("user_id", user.ID.Hex()).Msg("Failed to send email confirmation")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to change email")
+	}
+
+	h.Logger.Info().Str("user_id", user.ID.Hex()).Msg("Email change requested")
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"detail": "A confirmation link has been sent to the new email address",
+	})
+}
+
+// ConfirmEmail applies an email change and lets the previous address know.
+func (h *EmailChangeHandler) ConfirmEmail(c *fiber.Ctx) error {
+	var confirmData struct {
+		Token string `json:"token"`
+	}
+	if err := c.BodyParser(&confirmData); err != nil || confirmData.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	change, err := h.DB.ConsumeEmailChange(tokens.Hash(confirmData.Token))
+	if err != nil {
+		h.Logger.Warn().Err(err).Msg("Invalid email change token")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired confirmation token"})
+	}
+
+	user, err := h.DB.GetUserByID(change.UserID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired confirmation token"})
+	}
+	previous := user.Email
+
+	user.Email = change.Email
+	if err := h.DB.UpdateUser(user.ID.Hex(), user, map[string]bool{"email": true}); err != nil {
+		h.Logger.Error().Err(err).Str("user_id", user.ID.Hex()).Msg("Failed to update email")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not change email"})
+	}
+
+	if previous != "" && previous != change.Email {
+		err := h.Sender.Send(mailer.Message{
+			To:      previous,
+			Subject: "Your glamapp email address was changed",
+			Body:    "The email address of your glamapp account " + user.Name + " was changed to " + change.Email + ". If you did not do this, reset your password and contact us.",
+		})
+		if err != nil {
+			h.Logger.Error().Err(err).Str("user_id", user.ID.Hex()).Msg("Failed to notify previous email address")
+		}
+	}
+
+	h.Logger.Info().Str("user_id", user.ID.Hex()).Msg("Email changed successfully")
+	return c.JSON(fiber.Map{"detail": "Email changed successfully"})
+}
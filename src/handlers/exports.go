@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"glamapp/src/database"
+	"glamapp/src/models"
+	"glamapp/src/storage"
+)
+
+type ExportHandler struct {
+	DB     *database.MongoDB
+	Blobs  storage.BlobStore
+	Logger zerolog.Logger
+	// Interval is how long a user has to wait between two exports.
+	Interval time.Duration
+}
+
+func NewExportHandler(db *database.MongoDB, blobs storage.BlobStore, logger zerolog.Logger, interval time.Duration) *ExportHandler {
+	return &ExportHandler{
+		DB:       db,
+		Blobs:    blobs,
+		Logger:   logger,
+		Interval: interval,
+	}
+}
+
+// CreateExport starts building an archive of the user's data. While an
+// export is in progress, requesting another one returns it instead, and
+// after one finished the next can only be requested once Interval passed.
+func (h *ExportHandler) CreateExport(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	export, created, err := h.DB.CreateDataExport(user.ID, h.Interval)
+	if errors.Is(err, database.ErrExportTooSoon) {
+		retryAfter := int(math.Ceil(time.Until(export.CreatedAt.Add(h.Interval)).Seconds()))
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+		return fiber.NewError(fiber.StatusTooManyRequests, "A data export was already made recently")
+	}
+	if err != nil {
+		h.Logger.Error().Err(err).Str("user_id", user.ID.Hex()).Msg("Failed to create data export")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to start data export")
+	}
+
+	detail := "Data export already in progress"
+	if created {
+		detail = "Data export started"
+		h.Logger.Info().Str("user_id", user.ID.Hex()).Str("export_id", export.ID.Hex()).Msg("Data export requested")
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"detail": detail,
+		"export": export.ToResponse(),
+	})
+}
+
+// GetExport downloads a finished archive. Until it is ready the export's
+// status is returned with 202 Accepted.
+func (h *ExportHandler) GetExport(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	exportID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid export ID")
+	}
+
+	export, err := h.DB.GetDataExport(exportID, user.ID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return fiber.NewError(fiber.StatusNotFound, "Export not found")
+	}
+	if err != nil {
+		h.Logger.Error().Err(err).Str("export_id", exportID.Hex()).Msg("Failed to fetch data export")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to fetch data export")
+	}
+
+	switch export.Status {
+	case models.DataExportFailed:
+		return fiber.NewError(fiber.StatusInternalServerError, "Data export failed, please request a new one")
+	case models.DataExportCompleted:
+	default:
+		return c.Status(fiber.StatusAccepted).JSON(export.ToResponse())
+	}
+
+	archive, err := h.Blobs.Open(c.UserContext(), export.BlobKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		return fiber.NewError(fiber.StatusNotFound, "Export not found")
+	}
+	if err != nil {
+		h.Logger.Error().Err(err).Str("export_id", exportID.Hex()).Msg("Failed to read data export")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to read data export")
+	}
+
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="glamapp-%s-%s.zip"`, user.Name, export.CreatedAt.Format("2006-01-02")))
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	// The stream is closed by fiber once the response is written.
+	return c.SendStream(archive, int(export.Size))
+}
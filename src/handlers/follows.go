@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"glamapp/src/database"
+	"glamapp/src/models"
+)
+
+type FollowHandler struct {
+	DB     *database.MongoDB
+	Logger zerolog.Logger
+}
+
+func NewFollowHandler(db *database.MongoDB, logger zerolog.Logger) *FollowHandler {
+	return &FollowHandler{
+		DB:     db,
+		Logger: logger,
+	}
+}
+
+func (h *FollowHandler) Follow(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	followee, err := h.DB.GetUser(c.Params("id"))
+	if err != nil || followee.Deactivated() {
+		return fiber.NewError(fiber.StatusNotFound, "User not found")
+	}
+	if followee.ID == user.ID {
+		return fiber.NewError(fiber.StatusBadRequest, "You can't follow yourself")
+	}
+
+	blocked, err := h.DB.IsBlocked(user.ID, followee.ID)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to follow user")
+	}
+	if blocked {
+		return fiber.NewError(fiber.StatusForbidden, "You can't follow this user")
+	}
+
+	follow, created, err := h.DB.Follow(user.ID, followee)
+	if err != nil {
+		h.Logger.Error().Err(err).Str("user_id", user.ID.Hex()).Str("followee_id", followee.ID.Hex()).Msg("Failed to follow user")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to follow user")
+	}
+	if !created {
+		detail := "Already following this user"
+		if follow.Pending() {
+			detail = "Follow request already sent"
+		}
+		return c.JSON(fiber.Map{
+			"detail": detail,
+			"status": follow.Status,
+		})
+	}
+
+	if follow.Pending() {
+		h.Logger.Info().Str("user_id", user.ID.Hex()).Str("followee_id", followee.ID.Hex()).Msg("Follow requested")
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"detail": "Follow request sent",
+			"status": follow.Status,
+		})
+	}
+
+	h.Logger.Info().Str("user_id", user.ID.Hex()).Str("followee_id", followee.ID.Hex()).Msg("User followed")
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"detail": "User followed successfully",
+		"status": follow.Status,
+	})
+}
+
+func (h *FollowHandler) Unfollow(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	followeeID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid user ID")
+	}
+
+	removed, err := h.DB.Unfollow(user.ID, followeeID)
+	if err != nil {
+		h.Logger.Error().Err(err).Str("user_id", user.ID.Hex()).Str("followee_id", followeeID.Hex()).Msg("Failed to unfollow user")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to unfollow user")
+	}
+	if !removed {
+		return fiber.NewError(fiber.StatusNotFound, "You are not following this user")
+	}
+
+	h.Logger.Info().Str("user_id", user.ID.Hex()).Str("followee_id", followeeID.Hex()).Msg("User unfollowed")
+	return c.JSON(fiber.Map{
+		"detail": "User unfollowed successfully",
+	})
+}
+
+// GetFollowRequests lists the users waiting for approval to follow the
+// authenticated user.
+func (h *FollowHandler) GetFollowRequests(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		return err
+	}
+
+	users, err := h.DB.GetFollowRequests(user.ID, limit, offset)
+	if err != nil {
+		h.Logger.Error().Err(err).Str("user_id", user.ID.Hex()).Msg("Failed to fetch follow requests")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to fetch follow requests")
+	}
+
+	baseURL := c.BaseURL()
+	responseUsers := make([]map[string]interface{}, 0, len(users))
+	for _, u := range users {
+		responseUsers = append(responseUsers, u.ToResponse(baseURL))
+	}
+
+	return c.JSON(fiber.Map{
+		"data":   responseUsers,
+		"count":  len(responseUsers),
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+func (h *FollowHandler) ApproveFollowRequest(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	followerID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid user ID")
+	}
+
+	approved, err := h.DB.ApproveFollowRequest(user.ID, followerID)
+	if err != nil {
+		h.Logger.Error().Err(err).Str("user_id", user.ID.Hex()).Str("follower_id", followerID.Hex()).Msg("Failed to approve follow request")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to approve follow request")
+	}
+	if !approved {
+		return fiber.NewError(fiber.StatusNotFound, "Follow request not found")
+	}
+
+	h.Logger.Info().Str("user_id", user.ID.Hex()).Str("follower_id", followerID.Hex()).Msg("Follow request approved")
+	return c.JSON(fiber.Map{
+		"detail": "Follow request approved",
+	})
+}
+
+func (h *FollowHandler) RejectFollowRequest(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	followerID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid user ID")
+	}
+
+	rejected, err := h.DB.RejectFollowRequest(user.ID, followerID)
+	if err != nil {
+		h.Logger.Error().Err(err).Str("user_id", user.ID.Hex()).Str("follower_id", followerID.Hex()).Msg("Failed to reject follow request")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to reject follow request")
+	}
+	if !rejected {
+		return fiber.NewError(fiber.StatusNotFound, "Follow request not found")
+	}
+
+	h.Logger.Info().Str("user_id", user.ID.Hex()).Str("follower_id", followerID.Hex()).Msg("Follow request rejected")
+	return c.JSON(fiber.Map{
+		"detail": "Follow request rejected",
+	})
+}
+
+func (h *FollowHandler) GetFollowers(c *fiber.Ctx) error {
+	return h.listFollows(c, h.DB.GetFollowers)
+}
+
+func (h *FollowHandler) GetFollowing(c *fiber.Ctx) error {
+	return h.listFollows(c, h.DB.GetFollowing)
+}
+
+func (h *FollowHandler) listFollows(c *fiber.Ctx, fetch func(primitive.ObjectID, int64, int64) ([]models.User, error)) error {
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		return err
+	}
+
+	user, err := h.DB.GetUser(c.Params("id"))
+	if err != nil || user.Deactivated() {
+		return fiber.NewError(fiber.StatusNotFound, "User not found")
+	}
+
+	users, err := fetch(user.ID, limit, offset)
+	if err != nil {
+		h.Logger.Error().Err(err).Str("user_id", user.ID.Hex()).Msg("Failed to fetch follows")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to fetch users")
+	}
+
+	baseURL := c.BaseURL()
+	responseUsers := make([]map[string]interface{}, 0, len(users))
+	for _, u := range users {
+		responseUsers = append(responseUsers, u.ToResponse(baseURL))
+	}
+
+	return c.JSON(fiber.Map{
+		"data":   responseUsers,
+		"count":  len(responseUsers),
+		"limit":  limit,
+		"offset": offset,
+	})
+}
@@ -0,0 +1,414 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"glamapp/src/database"
+	"glamapp/src/models"
+	"glamapp/src/oidc"
+	"glamapp/src/tokens"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	oidcStateTTL        = 10 * time.Minute
+	maxUsernameAttempts = 5
+
+	// oidcBrowserCookie binds a flow to the browser that started it.
+	oidcBrowserCookie = "glamapp_oidc"
+	oidcCookiePath    = "/auth/oidc"
+)
+
+// OIDCHandler signs users in with external OpenID Connect providers. It
+// reuses AuthHandler to start sessions once the provider vouched for a user.
+type OIDCHandler struct {
+	*AuthHandler
+	Providers map[string]*oidc.Provider
+}
+
+func NewOIDCHandler(authHandler *AuthHandler, providers map[string]*oidc.Provider) *OIDCHandler {
+	return &OIDCHandler{
+		AuthHandler: authHandler,
+		Providers:   providers,
+	}
+}
+
+func (h *OIDCHandler) Login(c *fiber.Ctx) error {
+	provider, ok := h.Providers[c.Params("provider")]
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Unknown provider"})
+	}
+
+	authURL, err := beginOIDCFlow(c, h.DB, provider, c.QueryBool("reactivate"))
+	if err != nil {
+		h.Logger.Error().Err(err).Str("provider", provider.Name).Msg("Failed to start OIDC login")
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Could not reach the identity provider"})
+	}
+
+	return c.Redirect(authURL, fiber.StatusFound)
+}
+
+// StartLink opens a link request created by IdentityHandler.LinkIdentity in
+// the browser, binds it to that browser and redirects to the provider. API
+// clients cannot set the cookie the callback checks themselves.
+func (h *OIDCHandler) StartLink(c *fiber.Ctx) error {
+	provider, ok := h.Providers[c.Params("provider")]
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Unknown provider"})
+	}
+
+	browser, err := tokens.Generate()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not link identity"})
+	}
+
+	stateValue := c.Query("state")
+	state, err := h.DB.BindOIDCState(tokens.Hash(stateValue), tokens.Hash(browser))
+	if err != nil || state.Provider != provider.Name {
+		h.Logger.Warn().Err(err).Str("provider", provider.Name).Msg("Invalid OIDC link request")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired link request"})
+	}
+
+	authURL, err := provider.AuthCodeURL(c.Context(), stateValue, state.Nonce, state.CodeVerifier)
+	if err != nil {
+		h.Logger.Error().Err(err).Str("provider", provider.Name).Msg("Failed to start OIDC link")
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Could not reach the identity provider"})
+	}
+
+	setOIDCBrowserCookie(c, browser, state.ExpiresAt)
+	return c.Redirect(authURL, fiber.StatusFound)
+}
+
+func (h *OIDCHandler) Callback(c *fiber.Ctx) error {
+	provider, ok := h.Providers[c.Params("provider")]
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Unknown provider"})
+	}
+
+	if providerError := c.Query("error"); providerError != "" {
+		h.Logger.Warn().Str("provider", provider.Name).Str("error", providerError).Msg("OIDC provider returned an error")
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Login was cancelled or denied"})
+	}
+
+	state, err := h.DB.ConsumeOIDCState(tokens.Hash(c.Query("state")))
+	if err != nil || state.Provider != provider.Name {
+		h.Logger.Warn().Err(err).Str("provider", provider.Name).Msg("Invalid OIDC state")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired login request"})
+	}
+
+	browser := c.Cookies(oidcBrowserCookie)
+	clearOIDCBrowserCookie(c)
+	if browser == "" || subtle.ConstantTimeCompare([]byte(tokens.Hash(browser)), []byte(state.BrowserHash)) != 1 {
+		h.Logger.Warn().Str("provider", provider.Name).Msg("OIDC callback from another browser")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired login request"})
+	}
+
+	claims, err := provider.Exchange(c.Context(), c.Query("code"), state.CodeVerifier, state.Nonce)
+	if err != nil {
+		h.Logger.Error().Err(err).Str("provider", provider.Name).Msg("Failed to exchange OIDC code")
+		if errors.Is(err, oidc.ErrInvalidIDToken) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid identity token"})
+		}
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Could not reach the identity provider"})
+	}
+
+	if state.UserID != nil {
+		return h.link(c, provider, *state.UserID, claims)
+	}
+
+	user, err := h.findOrCreateUser(provider, claims)
+	if err != nil {
+		h.Logger.Error().Err(err).Str("provider", provider.Name).Msg("Failed to sign in with OIDC")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not login"})
+	}
+
+	if refused, err := h.refuseDeactivated(c, user, state.Reactivate); refused {
+		return err
+	}
+
+	if user.TOTPEnabled {
+		return h.sendMFAToken(c, user)
+	}
+
+	return h.startSession(c, user, state.Reactivate)
+}
+
+func (h *OIDCHandler) link(c *fiber.Ctx, provider *oidc.Provider, userID primitive.ObjectID, claims *oidc.IDClaims) error {
+	// The account may have been deleted or deactivated since the link was
+	// requested.
+	user, err := h.DB.GetUserByID(userID)
+	if err != nil || user.Deactivated() {
+		h.Logger.Warn().Err(err).Str("provider", provider.Name).Str("user_id", userID.Hex()).Msg("OIDC link for an unavailable account")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired link request"})
+	}
+
+	existing, err := h.DB.GetIdentity(provider.Name, claims.Subject)
+	if err == nil {
+		if existing.UserID == userID {
+			return c.JSON(fiber.Map{"detail": "Identity already linked"})
+		}
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "This identity is linked to another account"})
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not link identity"})
+	}
+
+	identity := &models.Identity{UserID: userID, Provider: provider.Name, Subject: claims.Subject, Email: claims.Email}
+	if err := h.DB.CreateIdentity(identity); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not link identity"})
+	}
+
+	h.Logger.Info().Str("provider", provider.Name).Str("user_id", userID.Hex()).Msg("Identity linked")
+	return c.JSON(fiber.Map{"detail": "Identity linked successfully"})
+}
+
+// findOrCreateUser returns the user linked to the identity, creating both on
+// the first login. Existing accounts are never matched by email, since that
+// would let anyone controlling a provider account take them over.
+func (h *OIDCHandler) findOrCreateUser(provider *oidc.Provider, claims *oidc.IDClaims) (*models.User, error) {
+	identity, err := h.DB.GetIdentity(provider.Name, claims.Subject)
+	if err == nil {
+		return h.DB.GetUserByID(identity.UserID)
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	user := models.NewUser()
+	user.ID = primitive.NewObjectID()
+	if claims.EmailVerified {
+		user.Email = claims.Email
+	}
+
+	if err := h.createWithAvailableName(user, usernameFromClaims(provider, claims)); err != nil {
+		return nil, err
+	}
+
+	identity = &models.Identity{UserID: user.ID, Provider: provider.Name, Subject: claims.Subject, Email: claims.Email}
+	if err := h.DB.CreateIdentity(identity); err != nil {
+		return nil, err
+	}
+
+	h.Logger.Info().Str("provider", provider.Name).Str("user_id", user.ID.Hex()).Msg("User created from OIDC identity")
+	return user, nil
+}
+
+// createWithAvailableName creates user named base, appending random digits
+// while the name is taken. The unique index on names decides, so concurrent
+// sign-ups deriving the same name cannot both get it.
+func (h *OIDCHandler) createWithAvailableName(user *models.User, base string) error {
+	user.Name = base
+	for attempt := 0; attempt < maxUsernameAttempts; attempt++ {
+		err := h.DB.CreateUser(user)
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+
+		suffix, err := rand.Int(rand.Reader, big.NewInt(10000))
+		if err != nil {
+			return err
+		}
+		user.Name = fmt.Sprintf("%s%04d", base, suffix.Int64())
+	}
+
+	return fmt.Errorf("no available username for %q", base)
+}
+
+func usernameFromClaims(provider *oidc.Provider, claims *oidc.IDClaims) string {
+	candidates := []string{claims.PreferredUsername, strings.Split(claims.Email, "@")[0], claims.Name}
+	for _, candidate := range candidates {
+		if name := sanitizeUsername(candidate); models.ValidateUsername(name) == nil {
+			return name
+		}
+	}
+	return provider.Name + "_user"
+}
+
+func sanitizeUsername(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '.':
+			b.WriteRune(r)
+		case r == ' ' || r == '-':
+			b.WriteRune('_')
+		}
+	}
+
+	name = b.String()
+	for strings.Contains(name, "..") {
+		name = strings.ReplaceAll(name, "..", ".")
+	}
+	// Leave room for the numeric suffix added on collisions.
+	if len(name) > models.MaxUsernameLength-4 {
+		name = name[:models.MaxUsernameLength-4]
+	}
+	return strings.Trim(name, "._")
+}
+
+// beginOIDCFlow starts a login and returns the provider URL to send the user
+// to. The flow is bound to the current browser with a cookie that the
+// callback checks. reactivate restores a deactivated account on login.
+func beginOIDCFlow(c *fiber.Ctx, db *database.MongoDB, provider *oidc.Provider, reactivate bool) (string, error) {
+	browser, err := tokens.Generate()
+	if err != nil {
+		return "", err
+	}
+
+	stateValue, state, err := newOIDCState(provider, nil, reactivate, tokens.Hash(browser))
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := provider.AuthCodeURL(c.Context(), stateValue, state.Nonce, state.CodeVerifier)
+	if err != nil {
+		return "", err
+	}
+	if err := db.CreateOIDCState(state); err != nil {
+		return "", err
+	}
+
+	setOIDCBrowserCookie(c, browser, state.ExpiresAt)
+	return authURL, nil
+}
+
+// newOIDCState returns a new state value and the state to store for it, with
+// its nonce and PKCE verifier.
+func newOIDCState(provider *oidc.Provider, userID *primitive.ObjectID, reactivate bool, browserHash string) (string, *models.OIDCState, error) {
+	secrets := make([]string, 3)
+	for i := range secrets {
+		secret, err := tokens.Generate()
+		if err != nil {
+			return "", nil, err
+		}
+		secrets[i] = secret
+	}
+	stateValue, nonce, verifier := secrets[0], secrets[1], secrets[2]
+
+	now := time.Now()
+	return stateValue, &models.OIDCState{
+		ID:           tokens.Hash(stateValue),
+		Provider:     provider.Name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		BrowserHash:  browserHash,
+		UserID:       userID,
+		Reactivate:   reactivate,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(oidcStateTTL),
+	}, nil
+}
+
+func setOIDCBrowserCookie(c *fiber.Ctx, browser string, expires time.Time) {
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcBrowserCookie,
+		Value:    browser,
+		Path:     oidcCookiePath,
+		Expires:  expires,
+		Secure:   c.Protocol() == "https",
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
+
+func clearOIDCBrowserCookie(c *fiber.Ctx) {
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcBrowserCookie,
+		Path:     oidcCookiePath,
+		Expires:  time.Unix(0, 0),
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
+
+type IdentityHandler struct {
+	DB        *database.MongoDB
+	Logger    zerolog.Logger
+	Providers map[string]*oidc.Provider
+}
+
+func NewIdentityHandler(db *database.MongoDB, logger zerolog.Logger, providers map[string]*oidc.Provider) *IdentityHandler {
+	return &IdentityHandler{
+		DB:        db,
+		Logger:    logger,
+		Providers: providers,
+	}
+}
+
+func (h *IdentityHandler) GetIdentities(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	identities, err := h.DB.GetUserIdentities(user.ID)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to fetch identities")
+	}
+
+	return c.JSON(fiber.Map{
+		"data":  identities,
+		"count": len(identities),
+	})
+}
+
+// LinkIdentity creates a link request for the logged in user. The client
+// opens the returned URL in a browser, which OIDCHandler.StartLink binds to
+// the flow before redirecting to the provider; the callback then links
+// instead of logging in.
+func (h *IdentityHandler) LinkIdentity(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	provider, ok := h.Providers[c.Params("provider")]
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, "Unknown provider")
+	}
+
+	stateValue, state, err := newOIDCState(provider, &user.ID, false, "")
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to start linking")
+	}
+	if err := h.DB.CreateOIDCState(state); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to start linking")
+	}
+
+	linkURL := c.BaseURL() + oidcCookiePath + "/" + url.PathEscape(provider.Name) + "/link?state=" + url.QueryEscape(stateValue)
+	return c.JSON(fiber.Map{"authorization_url": linkURL})
+}
+
+func (h *IdentityHandler) DeleteIdentity(c *fiber.Ctx) error {
+	id := c.Params("id")
+	user := c.Locals("user").(*models.User)
+
+	identityID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid identity ID")
+	}
+
+	if user.PasswordHash == "" {
+		identities, err := h.DB.GetUserIdentities(user.ID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Failed to unlink identity")
+		}
+		if len(identities) <= 1 {
+			return fiber.NewError(fiber.StatusConflict, "Set a password before unlinking your only identity")
+		}
+	}
+
+	if err := h.DB.DeleteUserIdentity(user.ID, identityID); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return fiber.NewError(fiber.StatusNotFound, "Identity not found")
+		}
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to unlink identity")
+	}
+
+	h.Logger.Info().Str("identity_id", id).Str("user_id", user.ID.Hex()).Msg("Identity unlinked")
+	return c.JSON(fiber.Map{"detail": "Identity unlinked successfully"})
+}
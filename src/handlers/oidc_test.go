@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"glamapp/src/database"
+	"glamapp/src/models"
+	"glamapp/src/oidc"
+	"glamapp/src/oidc/oidctest"
+	"glamapp/src/tokens"
+)
+
+// These tests need a MongoDB server, for example
+// GLAMAPP_TEST_MONGODB_URI=mongodb://localhost:27017. Every test uses a
+// fresh database that is dropped afterwards.
+func testDB(t *testing.T) *database.MongoDB {
+	t.Helper()
+
+	uri := os.Getenv("GLAMAPP_TEST_MONGODB_URI")
+	if uri == "" {
+		t.Skip("GLAMAPP_TEST_MONGODB_URI is not set")
+	}
+
+	db := database.NewMongoDB(uri, "glamapp_test_"+primitive.NewObjectID().Hex(), zerolog.Nop())
+	t.Cleanup(func() {
+		if err := db.Database().Drop(context.Background()); err != nil {
+			t.Errorf("drop test database: %v", err)
+		}
+	})
+	return db
+}
+
+type oidcTestApp struct {
+	app    *fiber.App
+	db     *database.MongoDB
+	issuer *oidctest.Issuer
+	user   *models.User
+}
+
+// newOIDCTestApp serves the OIDC routes for a "mock" provider backed by a
+// local issuer. Requests to /link act as the logged in user t.user.
+func newOIDCTestApp(t *testing.T) *oidcTestApp {
+	t.Helper()
+
+	db := testDB(t)
+	issuer := oidctest.NewIssuer(t, "glamapp", "secret")
+	providers := map[string]*oidc.Provider{
+		"mock": oidc.NewProvider(oidc.Config{
+			Name:         "mock",
+			Issuer:       issuer.URL,
+			ClientID:     issuer.ClientID,
+			ClientSecret: issuer.ClientSecret,
+			RedirectURL:  "http://127.0.0.1:3000/auth/oidc/mock/callback",
+		}),
+	}
+
+	keys, err := tokens.NewEphemeralKeySet("glamapp")
+	if err != nil {
+		t.Fatalf("NewEphemeralKeySet: %v", err)
+	}
+	lockout := database.LockoutPolicy{MaxFailures: 5, BaseDelay: time.Second, MaxDelay: time.Minute}
+	authHandler := NewAuthHandler(db, zerolog.Nop(), keys, 15*time.Minute, time.Hour, lockout, lockout)
+	oidcHandler := NewOIDCHandler(authHandler, providers)
+	identityHandler := NewIdentityHandler(db, zerolog.Nop(), providers)
+
+	ta := &oidcTestApp{app: fiber.New(), db: db, issuer: issuer}
+	ta.app.Get("/auth/oidc/:provider/login", oidcHandler.Login)
+	ta.app.Get("/auth/oidc/:provider/link", oidcHandler.StartLink)
+	ta.app.Get("/auth/oidc/:provider/callback", oidcHandler.Callback)
+	ta.app.Post("/link/:provider", func(c *fiber.Ctx) error {
+		c.Locals("user", ta.user)
+		return c.Next()
+	}, identityHandler.LinkIdentity)
+	return ta
+}
+
+func (ta *oidcTestApp) do(t *testing.T, req *http.Request) (*http.Response, map[string]interface{}) {
+	t.Helper()
+
+	resp, err := ta.app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", req.Method, req.URL, err)
+	}
+	defer resp.Body.Close()
+
+	body := map[string]interface{}{}
+	if strings.HasPrefix(resp.Header.Get(fiber.HeaderContentType), fiber.MIMEApplicationJSON) {
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("decode response of %s: %v", req.URL, err)
+		}
+	}
+	return resp, body
+}
+
+// startLogin begins a login and returns the provider URL and the cookie
+// binding the flow to the browser.
+func (ta *oidcTestApp) startLogin(t *testing.T) (string, *http.Cookie) {
+	t.Helper()
+
+	resp, _ := ta.do(t, httptest.NewRequest(http.MethodGet, "/auth/oidc/mock/login", nil))
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("login status = %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+	return resp.Header.Get(fiber.HeaderLocation), browserCookie(t, resp)
+}
+
+func (ta *oidcTestApp) callback(t *testing.T, code, state string, cookie *http.Cookie) (*http.Response, map[string]interface{}) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/oidc/mock/callback?code="+code+"&state="+state, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	return ta.do(t, req)
+}
+
+func browserCookie(t *testing.T, resp *http.Response) *http.Cookie {
+	t.Helper()
+
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == oidcBrowserCookie {
+			if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+				t.Fatalf("browser cookie must be HttpOnly and SameSite=Lax: %+v", cookie)
+			}
+			return cookie
+		}
+	}
+	t.Fatalf("response sets no %s cookie", oidcBrowserCookie)
+	return nil
+}
+
+func TestOIDCFirstLoginCreatesUser(t *testing.T) {
+	ta := newOIDCTestApp(t)
+	claims := map[string]interface{}{"preferred_username": "ghennadi", "email": "ghennadi@example.com", "email_verified": true}
+
+	authURL, cookie := ta.startLogin(t)
+	code, state := ta.issuer.Authorize(t, authURL, "subject-1", claims)
+	resp, body := ta.callback(t, code, state, cookie)
+	if resp.StatusCode != http.StatusOK || body["token"] == nil {
+		t.Fatalf("callback = %d %v, want tokens", resp.StatusCode, body)
+	}
+
+	identity, err := ta.db.GetIdentity("mock", "subject-1")
+	if err != nil {
+		t.Fatalf("GetIdentity: %v", err)
+	}
+	user, err := ta.db.GetUserByID(identity.UserID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if user.Name != "ghennadi" || user.Email != "ghennadi@example.com" {
+		t.Fatalf("created user %q <%s>, want ghennadi <ghennadi@example.com>", user.Name, user.Email)
+	}
+
+	// Signing in again finds the same account.
+	authURL, cookie = ta.startLogin(t)
+	code, state = ta.issuer.Authorize(t, authURL, "subject-1", claims)
+	if resp, body := ta.callback(t, code, state, cookie); resp.StatusCode != http.StatusOK {
+		t.Fatalf("second callback = %d %v", resp.StatusCode, body)
+	}
+	identities, err := ta.db.GetUserIdentities(user.ID)
+	if err != nil {
+		t.Fatalf("GetUserIdentities: %v", err)
+	}
+	if len(identities) != 1 {
+		t.Fatalf("user has %d identities after signing in twice, want 1", len(identities))
+	}
+}
+
+func TestOIDCCallbackRequiresBrowserCookie(t *testing.T) {
+	ta := newOIDCTestApp(t)
+
+	authURL, cookie := ta.startLogin(t)
+	code, state := ta.issuer.Authorize(t, authURL, "subject-1", nil)
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+	}{
+		{"missing", nil},
+		{"other browser", &http.Cookie{Name: cookie.Name, Value: "another-browser"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Each attempt consumes the state, so it needs a new flow.
+			authURL, _ := ta.startLogin(t)
+			code, state := ta.issuer.Authorize(t, authURL, "subject-1", nil)
+			if resp, body := ta.callback(t, code, state, tt.cookie); resp.StatusCode != http.StatusBadRequest {
+				t.Fatalf("callback = %d %v, want %d", resp.StatusCode, body, http.StatusBadRequest)
+			}
+		})
+	}
+
+	if _, err := ta.db.GetIdentity("mock", "subject-1"); err == nil {
+		t.Fatal("identity was created by a rejected callback")
+	}
+
+	if resp, body := ta.callback(t, code, state, cookie); resp.StatusCode != http.StatusOK {
+		t.Fatalf("callback from the starting browser = %d %v", resp.StatusCode, body)
+	}
+}
+
+// requestLink asks the API to link the mock provider to ta.user and returns
+// the URL the client opens in a browser.
+func (ta *oidcTestApp) requestLink(t *testing.T) string {
+	t.Helper()
+
+	resp, body := ta.do(t, httptest.NewRequest(http.MethodPost, "/link/mock", nil))
+	linkURL, _ := body["authorization_url"].(string)
+	if resp.StatusCode != http.StatusOK || linkURL == "" {
+		t.Fatalf("link = %d %v, want an authorization_url", resp.StatusCode, body)
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == oidcBrowserCookie {
+			t.Fatal("link API response sets the browser cookie")
+		}
+	}
+	return linkURL
+}
+
+// openLink opens linkURL like a browser and returns the provider URL and the
+// cookie binding the flow to that browser.
+func (ta *oidcTestApp) openLink(t *testing.T, linkURL string) (string, *http.Cookie) {
+	t.Helper()
+
+	resp, body := ta.do(t, httptest.NewRequest(http.MethodGet, linkURL, nil))
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("open link = %d %v, want %d", resp.StatusCode, body, http.StatusFound)
+	}
+	return resp.Header.Get(fiber.HeaderLocation), browserCookie(t, resp)
+}
+
+func TestOIDCLinkIdentity(t *testing.T) {
+	ta := newOIDCTestApp(t)
+
+	ta.user = models.NewUser()
+	ta.user.ID = primitive.NewObjectID()
+	ta.user.Name = "ghennadi"
+	if err := ta.db.CreateUser(ta.user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	// The API response sets no cookie, the browser opening the link does.
+	linkURL := ta.requestLink(t)
+	authURL, cookie := ta.openLink(t, linkURL)
+	if resp, _ := ta.do(t, httptest.NewRequest(http.MethodGet, linkURL, nil)); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("opening a link twice = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	code, state := ta.issuer.Authorize(t, authURL, "subject-1", nil)
+	if resp, body := ta.callback(t, code, state, cookie); resp.StatusCode != http.StatusOK || body["detail"] != "Identity linked successfully" {
+		t.Fatalf("callback = %d %v, want the identity linked", resp.StatusCode, body)
+	}
+
+	identity, err := ta.db.GetIdentity("mock", "subject-1")
+	if err != nil {
+		t.Fatalf("GetIdentity: %v", err)
+	}
+	if identity.UserID != ta.user.ID {
+		t.Fatalf("identity linked to %s, want %s", identity.UserID.Hex(), ta.user.ID.Hex())
+	}
+
+	// Signing in with the linked identity opens the existing account.
+	authURL, cookie = ta.startLogin(t)
+	code, state = ta.issuer.Authorize(t, authURL, "subject-1", nil)
+	if resp, body := ta.callback(t, code, state, cookie); resp.StatusCode != http.StatusOK || body["token"] == nil {
+		t.Fatalf("login with linked identity = %d %v", resp.StatusCode, body)
+	}
+	if identities, err := ta.db.GetUserIdentities(ta.user.ID); err != nil || len(identities) != 1 {
+		t.Fatalf("GetUserIdentities = %v, %v; want the one linked identity", identities, err)
+	}
+}
+
+func TestOIDCLinkRejectsDeactivatedAccount(t *testing.T) {
+	ta := newOIDCTestApp(t)
+
+	ta.user = models.NewUser()
+	ta.user.ID = primitive.NewObjectID()
+	ta.user.Name = "ghennadi"
+	if err := ta.db.CreateUser(ta.user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	authURL, cookie := ta.openLink(t, ta.requestLink(t))
+	if err := ta.db.DeactivateUser(ta.user.ID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("DeactivateUser: %v", err)
+	}
+
+	code, state := ta.issuer.Authorize(t, authURL, "subject-1", nil)
+	if resp, body := ta.callback(t, code, state, cookie); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("callback = %d %v, want %d", resp.StatusCode, body, http.StatusBadRequest)
+	}
+	if _, err := ta.db.GetIdentity("mock", "subject-1"); err == nil {
+		t.Fatal("identity was linked to a deactivated account")
+	}
+}
+
+func TestOIDCFirstLoginAvoidsTakenNames(t *testing.T) {
+	ta := newOIDCTestApp(t)
+
+	existing := models.NewUser()
+	existing.Name = "Ghennadi"
+	if err := ta.db.CreateUser(existing); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	authURL, cookie := ta.startLogin(t)
+	code, state := ta.issuer.Authorize(t, authURL, "subject-1", map[string]interface{}{"preferred_username": "ghennadi"})
+	if resp, body := ta.callback(t, code, state, cookie); resp.StatusCode != http.StatusOK {
+		t.Fatalf("callback = %d %v", resp.StatusCode, body)
+	}
+
+	identity, err := ta.db.GetIdentity("mock", "subject-1")
+	if err != nil {
+		t.Fatalf("GetIdentity: %v", err)
+	}
+	user, err := ta.db.GetUserByID(identity.UserID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if !strings.HasPrefix(user.Name, "ghennadi") || len(user.Name) != len("ghennadi")+4 {
+		t.Fatalf("new user is named %q, want ghennadi with a numeric suffix", user.Name)
+	}
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// parsePagination reads the limit and offset query parameters of list
+// endpoints, capping limit at maxPageLimit.
+func parsePagination(c *fiber.Ctx) (limit, offset int64, err error) {
+	limit, offset = defaultPageLimit, 0
+
+	if value := c.Query("limit"); value != "" {
+		limit, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || limit <= 0 {
+			return 0, 0, fiber.NewError(fiber.StatusBadRequest, "limit must be a positive number")
+		}
+		if limit > maxPageLimit {
+			limit = maxPageLimit
+		}
+	}
+
+	if value := c.Query("offset"); value != "" {
+		offset, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || offset < 0 {
+			return 0, 0, fiber.NewError(fiber.StatusBadRequest, "offset must not be negative")
+		}
+	}
+
+	return limit, offset, nil
+}
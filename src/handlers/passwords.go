@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"glamapp/src/database"
+	"glamapp/src/mailer"
+	"glamapp/src/models"
+	"glamapp/src/tokens"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+)
+
+type PasswordResetHandler struct {
+	DB       *database.MongoDB
+	Logger   zerolog.Logger
+	Sender   mailer.Sender
+	TTL      time.Duration
+	ResetURL string
+}
+
+func NewPasswordResetHandler(db *database.MongoDB, logger zerolog.Logger, sender mailer.Sender, ttl time.Duration, resetURL string) *PasswordResetHandler {
+	return &PasswordResetHandler{
+		DB:       db,
+		Logger:   logger,
+		Sender:   sender,
+		TTL:      ttl,
+		ResetURL: resetURL,
+	}
+}
+
+// ForgotPassword always answers with 202 so it cannot be used to find out
+// which user names exist.
+func (h *PasswordResetHandler) ForgotPassword(c *fiber.Ctx) error {
+	var forgotData struct {
+		Name string `json:"name"`
+	}
+
+	if err := c.BodyParser(&forgotData); err != nil || forgotData.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	accepted := func() error {
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"detail": "If the account exists and has an email address, a reset link has been sent",
+		})
+	}
+
+	user, err := h.DB.GetUserByName(forgotData.Name)
+	if err != nil {
+		return accepted()
+	}
+	if user.Email == "" {
+		h.Logger.Warn().Str("user_id", user.ID.Hex()).Msg("Password reset requested for a user without email")
+		return accepted()
+	}
+
+	token, err := tokens.Generate()
+	if err != nil {
+		h.Logger.Error().Err(err).Msg("Failed to generate password reset token")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not reset password"})
+	}
+
+	expiresAt := time.Now().Add(h.TTL)
+	if err := h.DB.CreatePasswordReset(user.ID, tokens.Hash(token), expiresAt); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not reset password"})
+	}
+
+	body := "Use this token to reset your glamapp password: " + token
+	if h.ResetURL != "" {
+		body = "Follow this link to reset your glamapp password: " + h.ResetURL + "?token=" + token
+	}
+	body += "\n\nIt expires at " + expiresAt.Format(time.RFC1123) + ". If you did not ask for a reset, ignore this message."
+
+	err = h.Sender.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your glamapp password",
+		Body:    body,
+	})
+	if err != nil {
+		h.Logger.Error().Err(err).Str("user_id", user.ID.Hex()).Msg("Failed to send password reset")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not reset password"})
+	}
+
+	h.Logger.Info().Str("user_id", user.ID.Hex()).Msg("Password reset requested")
+	return accepted()
+}
+
+func (h *PasswordResetHandler) ResetPassword(c *fiber.Ctx) error {
+	var resetData struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+
+	if err := c.BodyParser(&resetData); err != nil || resetData.Token == "" || resetData.NewPassword == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	// The token is only used up once the new password is accepted.
+	user := new(models.User)
+	if err := user.SetPassword(resetData.NewPassword); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	reset, err := h.DB.ConsumePasswordReset(tokens.Hash(resetData.Token))
+	if err != nil {
+		h.Logger.Warn().Err(err).Msg("Invalid password reset token")
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired reset token"})
+	}
+
+	if err := h.DB.UpdateUser(reset.UserID.Hex(), user, map[string]bool{"password_hash": true}); err != nil {
+		h.Logger.Error().Err(err).Str("user_id", reset.UserID.Hex()).Msg("Failed to update password")
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not reset password"})
+	}
+
+	if _, err := h.DB.DeleteUserSessions(reset.UserID); err != nil {
+		h.Logger.Error().Err(err).Str("user_id", reset.UserID.Hex()).Msg("Failed to revoke sessions after password reset")
+	}
+
+	h.Logger.Info().Str("user_id", reset.UserID.Hex()).Msg("Password reset successfully")
+	return c.JSON(fiber.Map{"detail": "Password reset successfully"})
+}
@@ -1,9 +1,11 @@
 package handlers
 
 import (
+	"errors"
 	"glamapp/src/database"
 	"glamapp/src/models"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/rs/zerolog"
@@ -12,12 +14,20 @@ import (
 type PostHandler struct {
 	DB     *database.MongoDB
 	Logger zerolog.Logger
+	// EditWindow limits how long after creation a post can be edited. Zero
+	// allows edits at any time.
+	EditWindow time.Duration
+	// CommentMaxDepth is how deep replies to comments can be nested. Zero
+	// allows comments on posts only.
+	CommentMaxDepth int
 }
 
-func NewPostHandler(db *database.MongoDB, logger zerolog.Logger) *PostHandler {
+func NewPostHandler(db *database.MongoDB, logger zerolog.Logger, editWindow time.Duration, commentMaxDepth int) *PostHandler {
 	return &PostHandler{
-		DB:     db,
-		Logger: logger,
+		DB:              db,
+		Logger:          logger,
+		EditWindow:      editWindow,
+		CommentMaxDepth: commentMaxDepth,
 	}
 }
 
@@ -29,6 +39,7 @@ func (h *PostHandler) CreatePost(c *fiber.Ctx) error {
 		h.Logger.Error().Err(err).Msg("Failed to parse post data")
 		return fiber.NewError(fiber.StatusBadRequest, err.Error())
 	}
+	post.AuthorPrivate = user.Private
 
 	id, err := h.DB.CreatePost(&post, user.ID)
 	if err != nil {
@@ -49,6 +60,8 @@ func (h *PostHandler) LikePost(c *fiber.Ctx) error {
 	err := h.DB.LikePost(postID, user.ID)
 	if err != nil {
 		switch {
+		case errors.Is(err, database.ErrBlocked), errors.Is(err, database.ErrPrivate):
+			return fiber.NewError(fiber.StatusNotFound, "Post not found")
 		case err.Error() == "post already liked or not found":
 			return fiber.NewError(fiber.StatusBadRequest, "Post already liked or not found")
 		case strings.Contains(err.Error(), "failed to update user"):
@@ -66,21 +79,179 @@ func (h *PostHandler) LikePost(c *fiber.Ctx) error {
 	})
 }
 
+func (h *PostHandler) UnlikePost(c *fiber.Ctx) error {
+	postID := c.Params("id")
+	user := c.Locals("user").(*models.User)
+
+	err := h.DB.UnlikePost(postID, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrNotLiked):
+			return fiber.NewError(fiber.StatusBadRequest, "Post not liked or not found")
+		case strings.Contains(err.Error(), "invalid post ID"):
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid post ID")
+		default:
+			return fiber.NewError(fiber.StatusInternalServerError, "Failed to unlike post")
+		}
+	}
+
+	h.Logger.Info().Str("post_id", postID).Str("user_id", user.ID.Hex()).Msg("Post unliked successfully")
+	return c.JSON(fiber.Map{
+		"detail": "Post unliked successfully",
+	})
+}
+
+// GetPostLikes lists the users who liked a post, most recent first.
+func (h *PostHandler) GetPostLikes(c *fiber.Ctx) error {
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		return err
+	}
+
+	post, err := h.visiblePost(c)
+	if err != nil {
+		return err
+	}
+
+	users, err := h.DB.GetPostLikers(post.ID, limit, offset)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to fetch likes")
+	}
+
+	baseURL := c.BaseURL()
+	responseUsers := make([]map[string]interface{}, 0, len(users))
+	for _, u := range users {
+		responseUsers = append(responseUsers, u.ToSummary(baseURL))
+	}
+
+	return c.JSON(fiber.Map{
+		"data":   responseUsers,
+		"count":  len(responseUsers),
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
 func (h *PostHandler) GetPost(c *fiber.Ctx) error {
+	post, err := h.visiblePost(c)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"data": post,
+	})
+}
+
+// visiblePost fetches the post in the id parameter and answers with 404 when
+// the user may not see it.
+func (h *PostHandler) visiblePost(c *fiber.Ctx) (*models.Post, error) {
 	id := c.Params("id")
+	user := c.Locals("user").(*models.User)
+
+	post, err := h.DB.GetPost(id)
+	if err != nil {
+		h.Logger.Error().Err(err).Str("id", id).Msg("Failed to fetch post")
+		return nil, fiber.NewError(fiber.StatusNotFound, "Post not found")
+	}
+	if post.AuthorDeactivated {
+		return nil, fiber.NewError(fiber.StatusNotFound, "Post not found")
+	}
+
+	visible, err := h.DB.CanSeePrivatePost(user.ID, post)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "Failed to fetch post")
+	}
+	if !visible {
+		return nil, fiber.NewError(fiber.StatusNotFound, "Post not found")
+	}
+
+	blocked, err := h.DB.IsBlocked(user.ID, post.AuthorID)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "Failed to fetch post")
+	}
+	if blocked {
+		return nil, fiber.NewError(fiber.StatusNotFound, "Post not found")
+	}
+
+	return post, nil
+}
+
+// EditPost changes the content of a post. Only the author can edit, within
+// EditWindow if one is configured, and the previous content is kept as a
+// revision.
+func (h *PostHandler) EditPost(c *fiber.Ctx) error {
+	id := c.Params("id")
+	user := c.Locals("user").(*models.User)
+
 	post, err := h.DB.GetPost(id)
 	if err != nil {
 		h.Logger.Error().Err(err).Str("id", id).Msg("Failed to fetch post")
 		return fiber.NewError(fiber.StatusNotFound, "Post not found")
 	}
 
+	if post.AuthorID != user.ID {
+		h.Logger.Warn().Str("id", id).Str("user_id", user.ID.Hex()).Msg("Forbidden post edit")
+		return fiber.NewError(fiber.StatusForbidden, "You can only edit your own posts")
+	}
+
+	if h.EditWindow > 0 && time.Since(post.CreatedAt) > h.EditWindow {
+		return fiber.NewError(fiber.StatusForbidden, "This post can no longer be edited")
+	}
+
+	edited := *post
+	if err := edited.Parse(c, user.ID, true); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if edited.Content == post.Content {
+		return c.JSON(fiber.Map{
+			"data": post,
+		})
+	}
+
+	updated, err := h.DB.EditPost(post, edited.Content)
+	if err != nil {
+		if errors.Is(err, database.ErrEditConflict) {
+			return fiber.NewError(fiber.StatusConflict, "The post was changed in the meantime, please try again")
+		}
+		h.Logger.Error().Err(err).Str("id", id).Msg("Failed to edit post")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to edit post")
+	}
+
+	h.Logger.Info().Str("id", id).Str("user_id", user.ID.Hex()).Msg("Post edited successfully")
 	return c.JSON(fiber.Map{
-		"data": post,
+		"data": updated,
+	})
+}
+
+func (h *PostHandler) GetPostRevisions(c *fiber.Ctx) error {
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		return err
+	}
+
+	post, err := h.visiblePost(c)
+	if err != nil {
+		return err
+	}
+
+	revisions, err := h.DB.GetPostRevisions(post.ID, limit, offset)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to fetch revisions")
+	}
+
+	return c.JSON(fiber.Map{
+		"data":   revisions,
+		"count":  len(revisions),
+		"limit":  limit,
+		"offset": offset,
 	})
 }
 
 func (h *PostHandler) GetPosts(c *fiber.Ctx) error {
-	posts, err := h.DB.GetPosts()
+	user := c.Locals("user").(*models.User)
+
+	posts, err := h.DB.GetPosts(user.ID)
 	if err != nil {
 		h.Logger.Error().Err(err).Msg("Failed to fetch posts")
 		return fiber.NewError(fiber.StatusInternalServerError, "Failed to fetch posts")
@@ -94,12 +265,25 @@ func (h *PostHandler) GetPosts(c *fiber.Ctx) error {
 
 func (h *PostHandler) DeletePost(c *fiber.Ctx) error {
 	id := c.Params("id")
+	user := c.Locals("user").(*models.User)
+
+	post, err := h.DB.GetPost(id)
+	if err != nil {
+		h.Logger.Error().Err(err).Str("id", id).Msg("Failed to fetch post")
+		return fiber.NewError(fiber.StatusNotFound, "Post not found")
+	}
+
+	if !user.CanModify(post.AuthorID, models.RoleModerator) {
+		h.Logger.Warn().Str("id", id).Str("user_id", user.ID.Hex()).Msg("Forbidden post deletion")
+		return fiber.NewError(fiber.StatusForbidden, "You are not allowed to delete this post")
+	}
+
 	if err := h.DB.DeletePost(id); err != nil {
 		h.Logger.Error().Err(err).Str("id", id).Msg("Failed to delete post")
 		return fiber.NewError(fiber.StatusInternalServerError, "Failed to delete post")
 	}
 
-	h.Logger.Info().Str("id", id).Msg("Post deleted successfully")
+	h.Logger.Info().Str("id", id).Str("user_id", user.ID.Hex()).Msg("Post deleted successfully")
 	return c.JSON(fiber.Map{
 		"detail": "Post deleted successfully",
 	})
@@ -107,7 +291,9 @@ func (h *PostHandler) DeletePost(c *fiber.Ctx) error {
 
 func (h *PostHandler) GetPostsByProfile(c *fiber.Ctx) error {
 	profileID := c.Params("profileId")
-	posts, err := h.DB.GetPostsByProfile(profileID)
+	user := c.Locals("user").(*models.User)
+
+	posts, err := h.DB.GetPostsByProfile(profileID, user.ID)
 	if err != nil {
 		h.Logger.Error().Err(err).Str("profileId", profileID).Msg("Failed to fetch posts")
 		return fiber.NewError(fiber.StatusInternalServerError, "Failed to fetch posts")
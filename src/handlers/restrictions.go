@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+
+	"glamapp/src/database"
+	"glamapp/src/models"
+)
+
+var restrictionPastTense = map[models.RestrictionKind]string{
+	models.RestrictionBlock: "blocked",
+	models.RestrictionMute:  "muted",
+}
+
+type RestrictionHandler struct {
+	DB     *database.MongoDB
+	Logger zerolog.Logger
+}
+
+func NewRestrictionHandler(db *database.MongoDB, logger zerolog.Logger) *RestrictionHandler {
+	return &RestrictionHandler{
+		DB:     db,
+		Logger: logger,
+	}
+}
+
+func (h *RestrictionHandler) Block(c *fiber.Ctx) error {
+	return h.restrict(c, models.RestrictionBlock)
+}
+
+func (h *RestrictionHandler) Unblock(c *fiber.Ctx) error {
+	return h.unrestrict(c, models.RestrictionBlock)
+}
+
+func (h *RestrictionHandler) Mute(c *fiber.Ctx) error {
+	return h.restrict(c, models.RestrictionMute)
+}
+
+func (h *RestrictionHandler) Unmute(c *fiber.Ctx) error {
+	return h.unrestrict(c, models.RestrictionMute)
+}
+
+func (h *RestrictionHandler) restrict(c *fiber.Ctx, kind models.RestrictionKind) error {
+	user := c.Locals("user").(*models.User)
+
+	target, err := h.DB.GetUser(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "User not found")
+	}
+	if target.ID == user.ID {
+		return fiber.NewError(fiber.StatusBadRequest, "You can't "+string(kind)+" yourself")
+	}
+
+	created, err := h.DB.Restrict(user.ID, target.ID, kind)
+	if err != nil {
+		h.Logger.Error().Err(err).Str("user_id", user.ID.Hex()).Str("target_id", target.ID.Hex()).Str("kind", string(kind)).Msg("Failed to restrict user")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to "+string(kind)+" user")
+	}
+
+	h.Logger.Info().Str("user_id", user.ID.Hex()).Str("target_id", target.ID.Hex()).Str("kind", string(kind)).Msg("User restricted")
+	status := fiber.StatusCreated
+	if !created {
+		status = fiber.StatusOK
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"detail": "User " + restrictionPastTense[kind] + " successfully",
+	})
+}
+
+func (h *RestrictionHandler) unrestrict(c *fiber.Ctx, kind models.RestrictionKind) error {
+	user := c.Locals("user").(*models.User)
+
+	target, err := h.DB.GetUser(c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "User not found")
+	}
+
+	removed, err := h.DB.Unrestrict(user.ID, target.ID, kind)
+	if err != nil {
+		h.Logger.Error().Err(err).Str("user_id", user.ID.Hex()).Str("target_id", target.ID.Hex()).Str("kind", string(kind)).Msg("Failed to lift restriction")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to un"+string(kind)+" user")
+	}
+	if !removed {
+		return fiber.NewError(fiber.StatusNotFound, "User is not "+restrictionPastTense[kind])
+	}
+
+	h.Logger.Info().Str("user_id", user.ID.Hex()).Str("target_id", target.ID.Hex()).Str("kind", string(kind)).Msg("Restriction lifted")
+	return c.JSON(fiber.Map{
+		"detail": "User un" + restrictionPastTense[kind] + " successfully",
+	})
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"errors"
+	"glamapp/src/database"
+	"glamapp/src/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type SessionHandler struct {
+	DB     *database.MongoDB
+	Logger zerolog.Logger
+}
+
+func NewSessionHandler(db *database.MongoDB, logger zerolog.Logger) *SessionHandler {
+	return &SessionHandler{
+		DB:     db,
+		Logger: logger,
+	}
+}
+
+func (h *SessionHandler) GetSessions(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+	current := c.Locals("session").(*models.Session)
+
+	sessions, err := h.DB.GetUserSessions(user.ID)
+	if err != nil {
+		h.Logger.Error().Err(err).Str("user_id", user.ID.Hex()).Msg("Failed to fetch sessions")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to fetch sessions")
+	}
+
+	responseSessions := []map[string]interface{}{}
+	for _, session := range sessions {
+		responseSessions = append(responseSessions, session.ToResponse(current.ID))
+	}
+
+	return c.JSON(fiber.Map{
+		"data":  responseSessions,
+		"count": len(responseSessions),
+	})
+}
+
+func (h *SessionHandler) DeleteSession(c *fiber.Ctx) error {
+	id := c.Params("id")
+	user := c.Locals("user").(*models.User)
+
+	sessionID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid session ID")
+	}
+
+	if err := h.DB.DeleteUserSession(user.ID, sessionID); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return fiber.NewError(fiber.StatusNotFound, "Session not found")
+		}
+		h.Logger.Error().Err(err).Str("id", id).Msg("Failed to delete session")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to delete session")
+	}
+
+	h.Logger.Info().Str("id", id).Str("user_id", user.ID.Hex()).Msg("Session revoked successfully")
+	return c.JSON(fiber.Map{
+		"detail": "Session revoked successfully",
+	})
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"glamapp/src/database"
+	"glamapp/src/models"
+	"glamapp/src/tokens"
+	"glamapp/src/totp"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+)
+
+const (
+	totpIssuer        = "glamapp"
+	recoveryCodeCount = 10
+)
+
+type TwoFactorHandler struct {
+	DB     *database.MongoDB
+	Logger zerolog.Logger
+}
+
+func NewTwoFactorHandler(db *database.MongoDB, logger zerolog.Logger) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		DB:     db,
+		Logger: logger,
+	}
+}
+
+func (h *TwoFactorHandler) Setup(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	if user.TOTPEnabled {
+		return fiber.NewError(fiber.StatusConflict, "Two-factor authentication is already enabled")
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		h.Logger.Error().Err(err).Msg("Failed to generate TOTP secret")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to set up two-factor authentication")
+	}
+
+	if err := h.DB.SetTOTPSecret(user.ID, secret); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to set up two-factor authentication")
+	}
+
+	return c.JSON(fiber.Map{
+		"secret": secret,
+		"uri":    totp.URI(totpIssuer, user.Name, secret),
+	})
+}
+
+func (h *TwoFactorHandler) Confirm(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	var confirmData struct {
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&confirmData); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if user.TOTPEnabled {
+		return fiber.NewError(fiber.StatusConflict, "Two-factor authentication is already enabled")
+	}
+	if user.TOTPSecret == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Two-factor authentication setup was not started")
+	}
+
+	step, ok := totp.Validate(user.TOTPSecret, confirmData.Code, time.Now())
+	if !ok {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid code")
+	}
+	fresh, err := h.DB.UseTOTPStep(user.ID, step)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to enable two-factor authentication")
+	}
+	if !fresh {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid code")
+	}
+
+	codes, err := totp.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		h.Logger.Error().Err(err).Msg("Failed to generate recovery codes")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to enable two-factor authentication")
+	}
+
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, tokens.Hash(code))
+	}
+
+	if err := h.DB.EnableTOTP(user.ID, hashes); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to enable two-factor authentication")
+	}
+
+	h.Logger.Info().Str("user_id", user.ID.Hex()).Msg("Two-factor authentication enabled")
+	return c.JSON(fiber.Map{
+		"detail":         "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+func (h *TwoFactorHandler) Disable(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	var disableData struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := c.BodyParser(&disableData); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if !user.TOTPEnabled {
+		return fiber.NewError(fiber.StatusBadRequest, "Two-factor authentication is not enabled")
+	}
+
+	if !user.CheckPassword(disableData.Password) || !verifySecondFactor(h.DB, user, disableData.Code) {
+		return fiber.NewError(fiber.StatusUnauthorized, "Invalid credentials")
+	}
+
+	if err := h.DB.DisableTOTP(user.ID); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to disable two-factor authentication")
+	}
+
+	h.Logger.Info().Str("user_id", user.ID.Hex()).Msg("Two-factor authentication disabled")
+	return c.JSON(fiber.Map{
+		"detail": "Two-factor authentication disabled",
+	})
+}
+
+// verifySecondFactor accepts either a current TOTP code that was not used
+// before or one of the user's unused recovery codes.
+func verifySecondFactor(db *database.MongoDB, user *models.User, code string) bool {
+	if step, ok := totp.Validate(user.TOTPSecret, code, time.Now()); ok {
+		fresh, err := db.UseTOTPStep(user.ID, step)
+		return err == nil && fresh
+	}
+
+	used, err := db.UseRecoveryCode(user.ID, tokens.Hash(totp.NormalizeRecoveryCode(code)))
+	return err == nil && used
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"glamapp/src/models"
+	"glamapp/src/tokens"
+	"glamapp/src/totp"
+)
+
+func TestVerifySecondFactorRejectsReplayedCode(t *testing.T) {
+	db := testDB(t)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret: %v", err)
+	}
+	user := &models.User{Name: "twofactor", Role: models.RoleUser}
+	if err := db.CreateUser(user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if err := db.SetTOTPSecret(user.ID, secret); err != nil {
+		t.Fatalf("SetTOTPSecret: %v", err)
+	}
+	user.TOTPSecret = secret
+
+	code, err := totp.Code(secret, time.Now())
+	if err != nil {
+		t.Fatalf("Code: %v", err)
+	}
+	if !verifySecondFactor(db, user, code) {
+		t.Fatal("first use of the code was rejected")
+	}
+	if verifySecondFactor(db, user, code) {
+		t.Fatal("replayed code was accepted")
+	}
+
+	// A code from the previous step is within the window but older than
+	// the one already used.
+	previous, err := totp.Code(secret, time.Now().Add(-totp.Period))
+	if err != nil {
+		t.Fatalf("Code: %v", err)
+	}
+	if previous != code && verifySecondFactor(db, user, previous) {
+		t.Fatal("code older than the last used one was accepted")
+	}
+}
+
+func TestVerifySecondFactorUsesRecoveryCodesOnce(t *testing.T) {
+	db := testDB(t)
+
+	user := &models.User{Name: "recovery", Role: models.RoleUser}
+	if err := db.CreateUser(user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	codes, err := totp.GenerateRecoveryCodes(2)
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes: %v", err)
+	}
+	if err := db.EnableTOTP(user.ID, []string{tokens.Hash(codes[0]), tokens.Hash(codes[1])}); err != nil {
+		t.Fatalf("EnableTOTP: %v", err)
+	}
+
+	if verifySecondFactor(db, user, "aaaa-aaaa") {
+		t.Fatal("unknown recovery code was accepted")
+	}
+	if !verifySecondFactor(db, user, codes[0]) {
+		t.Fatal("recovery code was rejected")
+	}
+	if verifySecondFactor(db, user, codes[0]) {
+		t.Fatal("recovery code was accepted twice")
+	}
+	if !verifySecondFactor(db, user, codes[1]) {
+		t.Fatal("remaining recovery code was rejected")
+	}
+}
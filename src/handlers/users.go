@@ -1,23 +1,40 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 
 	"glamapp/src/database"
+	"glamapp/src/jobs"
+	"glamapp/src/media"
 	"glamapp/src/models"
+	"glamapp/src/storage"
 
 	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type UserHandler struct {
 	DB     *database.MongoDB
+	Blobs  storage.BlobStore
 	Logger zerolog.Logger
+	// GracePeriod is how long a deactivated account can be restored before
+	// it is deleted.
+	GracePeriod time.Duration
 }
 
-func NewUserHandler(db *database.MongoDB, logger zerolog.Logger) *UserHandler {
+func NewUserHandler(db *database.MongoDB, blobs storage.BlobStore, logger zerolog.Logger, gracePeriod time.Duration) *UserHandler {
 	return &UserHandler{
-		DB:     db,
-		Logger: logger,
+		DB:          db,
+		Blobs:       blobs,
+		Logger:      logger,
+		GracePeriod: gracePeriod,
 	}
 }
 
@@ -25,6 +42,10 @@ func (h *UserHandler) UpdateUser(c *fiber.Ctx) error {
 	id := c.Params("id")
 	h.Logger.Debug().Str("id", id).Msg("Updating user")
 
+	if err := h.authorize(c, id); err != nil {
+		return err
+	}
+
 	user := new(models.User)
 	updatedFields, err := user.Parse(c, true)
 	if err != nil {
@@ -32,7 +53,10 @@ func (h *UserHandler) UpdateUser(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusBadRequest, err.Error())
 	}
 
-	if err := h.DB.UpdateUser(id, user, updatedFields); err != nil {
+	if err := h.saveUser(c, id, user, updatedFields); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fiber.NewError(fiber.StatusConflict, "Name is already taken")
+		}
 		h.Logger.Error().Err(err).Str("id", id).Msg("Failed to update user in database")
 		return fiber.NewError(fiber.StatusInternalServerError, "Failed to update user")
 	}
@@ -43,6 +67,38 @@ func (h *UserHandler) UpdateUser(c *fiber.Ctx) error {
 	})
 }
 
+// UpdateMe updates the profile of the authenticated user from a JSON or form
+// body and returns the updated profile.
+func (h *UserHandler) UpdateMe(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	update := new(models.User)
+	updatedFields, err := update.Parse(c, true)
+	if err != nil {
+		h.Logger.Error().Err(err).Str("id", user.ID.Hex()).Msg("Failed to parse profile update data")
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	if err := h.saveUser(c, user.ID.Hex(), update, updatedFields); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fiber.NewError(fiber.StatusConflict, "Name is already taken")
+		}
+		h.Logger.Error().Err(err).Str("id", user.ID.Hex()).Msg("Failed to update profile in database")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to update profile")
+	}
+
+	updated, err := h.DB.GetUserByID(user.ID)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to load updated profile")
+	}
+
+	h.Logger.Info().Str("id", user.ID.Hex()).Msg("Profile updated successfully")
+	response := updated.ToResponse(c.BaseURL())
+	response["email"] = updated.Email
+
+	return c.JSON(response)
+}
+
 func (h *UserHandler) GetUsers(c *fiber.Ctx) error {
 	users, err := h.DB.GetUsers()
 	if err != nil {
@@ -62,6 +118,43 @@ func (h *UserHandler) GetUsers(c *fiber.Ctx) error {
 	})
 }
 
+const maxSearchQueryLength = 50
+
+// SearchUsers looks users up by name or display name for mentions and
+// finding friends.
+func (h *UserHandler) SearchUsers(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	query := strings.TrimPrefix(strings.TrimSpace(c.Query("q")), "@")
+	if query == "" || len(query) > maxSearchQueryLength {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("q must be between 1 and %d characters", maxSearchQueryLength))
+	}
+
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		return err
+	}
+
+	users, err := h.DB.SearchUsers(query, user.ID, limit, offset)
+	if err != nil {
+		h.Logger.Error().Err(err).Str("query", query).Msg("Failed to search users")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to search users")
+	}
+
+	baseURL := c.BaseURL()
+	responseUsers := make([]map[string]interface{}, 0, len(users))
+	for _, u := range users {
+		responseUsers = append(responseUsers, u.ToResponse(baseURL))
+	}
+
+	return c.JSON(fiber.Map{
+		"data":   responseUsers,
+		"count":  len(responseUsers),
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
 func (h *UserHandler) GetUser(c *fiber.Ctx) error {
 	id := c.Params("id")
 	user, err := h.DB.GetUser(id)
@@ -70,6 +163,12 @@ func (h *UserHandler) GetUser(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusNotFound, "User not found")
 	}
 
+	// Admins can still look up deactivated accounts, e.g. to delete them.
+	requester := c.Locals("user").(*models.User)
+	if user.Deactivated() && !requester.HasRole(models.RoleAdmin) {
+		return fiber.NewError(fiber.StatusNotFound, "User not found")
+	}
+
 	baseURL := c.BaseURL()
 	response := user.ToResponse(baseURL)
 
@@ -79,25 +178,130 @@ func (h *UserHandler) GetUser(c *fiber.Ctx) error {
 func (h *UserHandler) Me(c *fiber.Ctx) error {
 	user := c.Locals("user").(*models.User)
 
-	return c.JSON(user.ToResponse(c.BaseURL()))
+	response := user.ToResponse(c.BaseURL())
+	response["email"] = user.Email
+
+	return c.JSON(response)
 }
 
+func (h *UserHandler) ChangePassword(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+	session := c.Locals("session").(*models.Session)
+
+	var passwordData struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := c.BodyParser(&passwordData); err != nil || passwordData.NewPassword == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "new_password is required")
+	}
+
+	// Users who signed up through an identity provider have no password yet
+	// and may set one without knowing the current one.
+	if user.PasswordHash != "" && !user.CheckPassword(passwordData.CurrentPassword) {
+		h.Logger.Warn().Str("user_id", user.ID.Hex()).Msg("Wrong current password on password change")
+		return fiber.NewError(fiber.StatusUnauthorized, "Current password is incorrect")
+	}
+
+	if err := user.SetPassword(passwordData.NewPassword); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	if err := h.DB.UpdateUser(user.ID.Hex(), user, map[string]bool{"password_hash": true}); err != nil {
+		h.Logger.Error().Err(err).Str("user_id", user.ID.Hex()).Msg("Failed to update password")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to change password")
+	}
+
+	revoked, err := h.DB.DeleteOtherUserSessions(user.ID, session.ID)
+	if err != nil {
+		h.Logger.Error().Err(err).Str("user_id", user.ID.Hex()).Msg("Failed to revoke other sessions")
+	}
+
+	h.Logger.Info().Str("user_id", user.ID.Hex()).Int64("revoked_sessions", revoked).Msg("Password changed successfully")
+	return c.JSON(fiber.Map{
+		"detail":           "Password changed successfully",
+		"revoked_sessions": revoked,
+	})
+}
+
+// DeleteUser deactivates the account. It can be restored by logging in
+// during the grace period and is deleted afterwards. Admins can delete an
+// account right away with ?permanent=true.
 func (h *UserHandler) DeleteUser(c *fiber.Ctx) error {
 	id := c.Params("id")
 	h.Logger.Debug().Str("id", id).Msg("Deleting user")
 
-	err := h.DB.DeleteUser(id)
+	if err := h.authorize(c, id); err != nil {
+		return err
+	}
+
+	user, err := h.DB.GetUser(id)
 	if err != nil {
-		h.Logger.Error().Err(err).Str("id", id).Msg("Failed to delete user from database")
+		return fiber.NewError(fiber.StatusNotFound, "User not found")
+	}
+
+	requester := c.Locals("user").(*models.User)
+	if c.QueryBool("permanent") {
+		if !requester.HasRole(models.RoleAdmin) {
+			return fiber.NewError(fiber.StatusForbidden, "Only admins can delete accounts permanently")
+		}
+		return h.deleteUserNow(c, user, requester)
+	}
+
+	if user.Deactivated() {
+		return c.JSON(fiber.Map{
+			"detail":   "User is already deactivated",
+			"purge_at": user.PurgeAt,
+		})
+	}
+
+	purgeAt := time.Now().Add(h.GracePeriod)
+	if err := h.DB.DeactivateUser(user.ID, purgeAt); err != nil {
+		h.Logger.Error().Err(err).Str("id", id).Msg("Failed to deactivate user")
 		return fiber.NewError(fiber.StatusInternalServerError, "Failed to delete user")
 	}
 
-	h.Logger.Info().Str("id", id).Msg("User deleted successfully")
+	h.Logger.Info().Str("id", id).Str("requested_by", requester.ID.Hex()).Time("purge_at", purgeAt).Msg("User deactivated")
 	return c.JSON(fiber.Map{
-		"detail": "User deleted successfully",
+		"detail":   "User deactivated",
+		"purge_at": purgeAt,
 	})
 }
 
+// deleteUserNow queues the deletion of the account and everything that
+// depends on it. The job runs in the background; its progress is available
+// on GET /users/:id/deletion.
+func (h *UserHandler) deleteUserNow(c *fiber.Ctx, user, requester *models.User) error {
+	id := user.ID.Hex()
+	job, err := h.DB.CreateDeletionJob(user.ID, requester.ID, jobs.DeletionStepCount())
+	if err != nil {
+		h.Logger.Error().Err(err).Str("id", id).Msg("Failed to queue user deletion")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to delete user")
+	}
+
+	h.Logger.Info().Str("id", id).Str("job_id", job.ID.Hex()).Str("requested_by", requester.ID.Hex()).Msg("User deletion queued")
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"detail": "User deletion started",
+		"job":    job.ToResponse(),
+	})
+}
+
+func (h *UserHandler) GetDeletion(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	if err := h.authorize(c, id); err != nil {
+		return err
+	}
+
+	userID, _ := primitive.ObjectIDFromHex(id)
+	job, err := h.DB.GetDeletionJobByUser(userID)
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "No deletion requested for this user")
+	}
+
+	return c.JSON(job.ToResponse())
+}
+
 func (h *UserHandler) GetAvatar(c *fiber.Ctx) error {
 	id := c.Params("id")
 	user, err := h.DB.GetUser(id)
@@ -105,10 +309,108 @@ func (h *UserHandler) GetAvatar(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusNotFound, "Profile not found")
 	}
 
-	if len(user.AvatarData) == 0 {
+	size := media.DefaultAvatarSize
+	if value := c.Query("size"); value != "" {
+		size, err = strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "size must be a positive number")
+		}
+	}
+
+	if user.Avatar == nil {
 		return fiber.NewError(fiber.StatusNotFound, "Avatar not found")
 	}
 
-	c.Set("Content-Type", user.AvatarType)
-	return c.Send(user.AvatarData)
+	data, err := h.Blobs.Get(c.UserContext(), user.Avatar.Key(size))
+	if errors.Is(err, storage.ErrNotFound) {
+		return fiber.NewError(fiber.StatusNotFound, "Avatar not found")
+	}
+	if err != nil {
+		h.Logger.Error().Err(err).Str("id", id).Msg("Failed to read avatar")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to read avatar")
+	}
+
+	c.Set(fiber.HeaderContentType, user.Avatar.ContentType)
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	c.Set(fiber.HeaderContentSecurityPolicy, "default-src 'none'")
+	c.Set(fiber.HeaderCacheControl, "private, max-age=300")
+	return c.Send(data)
+}
+
+func (h *UserHandler) SetRole(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid user ID")
+	}
+
+	var roleData struct {
+		Role models.Role `json:"role"`
+	}
+	if err := c.BodyParser(&roleData); err != nil || !roleData.Role.Valid() {
+		return fiber.NewError(fiber.StatusBadRequest, "Role must be one of user, moderator or admin")
+	}
+
+	if err := h.DB.SetUserRole(objectID, roleData.Role); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return fiber.NewError(fiber.StatusNotFound, "User not found")
+		}
+		h.Logger.Error().Err(err).Str("id", id).Msg("Failed to set user role")
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to set user role")
+	}
+
+	admin := c.Locals("user").(*models.User)
+	h.Logger.Info().Str("id", id).Str("role", string(roleData.Role)).Str("admin_id", admin.ID.Hex()).Msg("User role changed")
+	return c.JSON(fiber.Map{
+		"detail": "User role updated successfully",
+	})
+}
+
+// saveUser stores a newly uploaded avatar, updates the user and then removes
+// the avatar it replaced.
+func (h *UserHandler) saveUser(c *fiber.Ctx, id string, update *models.User, updatedFields map[string]bool) error {
+	var previous *models.AvatarRef
+	if update.PendingAvatar != nil {
+		existing, err := h.DB.GetUser(id)
+		if err != nil {
+			return err
+		}
+		previous = existing.Avatar
+
+		ref, err := storage.PutAvatar(c.UserContext(), h.Blobs, existing.ID, update.PendingAvatar.ContentType, update.PendingAvatar.Sizes)
+		if err != nil {
+			return err
+		}
+		update.Avatar = ref
+	}
+
+	if err := h.DB.UpdateUser(id, update, updatedFields); err != nil {
+		if err := storage.DeleteAvatar(c.UserContext(), h.Blobs, update.Avatar); err != nil {
+			h.Logger.Error().Err(err).Str("id", id).Msg("Failed to delete unused avatar")
+		}
+		return err
+	}
+
+	if err := storage.DeleteAvatar(c.UserContext(), h.Blobs, previous); err != nil {
+		h.Logger.Error().Err(err).Str("id", id).Msg("Failed to delete replaced avatar")
+	}
+	return nil
+}
+
+// authorize lets users change their own account and admins change anybody's.
+func (h *UserHandler) authorize(c *fiber.Ctx, id string) error {
+	user := c.Locals("user").(*models.User)
+
+	targetID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid user ID")
+	}
+
+	if !user.CanModify(targetID, models.RoleAdmin) {
+		h.Logger.Warn().Str("id", id).Str("user_id", user.ID.Hex()).Msg("Forbidden user modification")
+		return fiber.NewError(fiber.StatusForbidden, "You are not allowed to modify this user")
+	}
+
+	return nil
 }
@@ -0,0 +1,161 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"glamapp/src/database"
+	"glamapp/src/models"
+	"glamapp/src/storage"
+)
+
+const (
+	// deletionLease is how long a worker may hold a job without renewing it
+	// before another worker takes it over.
+	deletionLease       = 5 * time.Minute
+	deletionStepTimeout = 4 * time.Minute
+	maxDeletionAttempts = 5
+	deletionRetryDelay  = time.Minute
+)
+
+// deletionSteps run in this order. The user document goes last so a failed
+// job can always be retried by requesting the deletion again.
+var deletionSteps = []string{"lock", "likes", "comments", "posts", "notifications", "relationships", "history", "exports", "avatar", "user"}
+
+// DeletionStepCount is the number of steps of every deletion job, reported
+// as progress.
+func DeletionStepCount() int {
+	return len(deletionSteps)
+}
+
+type deletionStep func(ctx context.Context, userID primitive.ObjectID) error
+
+// DeletionRunner removes accounts queued with database.CreateDeletionJob.
+type DeletionRunner struct {
+	DB           *database.MongoDB
+	Blobs        storage.BlobStore
+	Logger       zerolog.Logger
+	PollInterval time.Duration
+
+	steps map[string]deletionStep
+}
+
+func NewDeletionRunner(db *database.MongoDB, blobs storage.BlobStore, logger zerolog.Logger) *DeletionRunner {
+	r := &DeletionRunner{
+		DB:           db,
+		Blobs:        blobs,
+		Logger:       logger,
+		PollInterval: 5 * time.Second,
+	}
+	r.steps = map[string]deletionStep{
+		"lock":          db.LockUserForDeletion,
+		"likes":         db.RemoveUserLikes,
+		"comments":      db.DeleteUserComments,
+		"posts":         db.DeleteUserPosts,
+		"notifications": db.DeleteUserNotifications,
+		"relationships": db.DeleteUserRelationships,
+		"history":       db.DeleteUserHistory,
+		"exports":       r.deleteExports,
+		"avatar":        r.deleteAvatar,
+		"user":          db.DeleteUserDocument,
+	}
+	return r
+}
+
+// Run processes jobs until ctx is cancelled.
+func (r *DeletionRunner) Run(ctx context.Context) {
+	poll(ctx, r.PollInterval, r.runPending)
+}
+
+func (r *DeletionRunner) runPending() {
+	for {
+		job, err := r.DB.ClaimDeletionJob(time.Now().Add(deletionLease))
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return
+		}
+		if err != nil {
+			r.Logger.Error().Err(err).Msg("Failed to claim deletion job")
+			return
+		}
+		r.run(job)
+	}
+}
+
+func (r *DeletionRunner) run(job *models.DeletionJob) {
+	logger := r.Logger.With().Str("job_id", job.ID.Hex()).Str("user_id", job.UserID.Hex()).Logger()
+	logger.Info().Int("attempt", job.Attempts).Msg("Running deletion job")
+
+	for _, step := range deletionSteps {
+		if job.HasCompleted(step) {
+			continue
+		}
+
+		if err := r.DB.StartDeletionStep(job.ID, step, time.Now().Add(deletionLease)); err != nil {
+			logger.Error().Err(err).Str("step", step).Msg("Failed to start deletion step")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), deletionStepTimeout)
+		err := r.steps[step](ctx, job.UserID)
+		cancel()
+		if err != nil {
+			r.fail(job, step, err, logger)
+			return
+		}
+
+		if err := r.DB.CompleteDeletionStep(job.ID, step); err != nil {
+			logger.Error().Err(err).Str("step", step).Msg("Failed to record deletion step")
+			return
+		}
+	}
+
+	if err := r.DB.FinishDeletionJob(job.ID); err != nil {
+		logger.Error().Err(err).Msg("Failed to finish deletion job")
+		return
+	}
+	logger.Info().Msg("User deleted")
+}
+
+func (r *DeletionRunner) fail(job *models.DeletionJob, step string, stepErr error, logger zerolog.Logger) {
+	if job.Attempts >= maxDeletionAttempts {
+		logger.Error().Err(stepErr).Str("step", step).Msg("Deletion job failed")
+		if err := r.DB.FailDeletionJob(job.ID, stepErr); err != nil {
+			logger.Error().Err(err).Msg("Failed to mark deletion job as failed")
+		}
+		return
+	}
+
+	logger.Warn().Err(stepErr).Str("step", step).Msg("Deletion step failed, retrying later")
+	if err := r.DB.ReleaseDeletionJob(job.ID, stepErr, time.Now().Add(deletionRetryDelay)); err != nil {
+		logger.Error().Err(err).Msg("Failed to release deletion job")
+	}
+}
+
+func (r *DeletionRunner) deleteAvatar(ctx context.Context, userID primitive.ObjectID) error {
+	user, err := r.DB.GetUserByID(userID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return storage.DeleteAvatar(ctx, r.Blobs, user.Avatar)
+}
+
+func (r *DeletionRunner) deleteExports(ctx context.Context, userID primitive.ObjectID) error {
+	exports, err := r.DB.GetUserDataExports(userID)
+	if err != nil {
+		return err
+	}
+	for _, export := range exports {
+		if err := deleteExport(ctx, r.DB, r.Blobs, &export); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+package jobs
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"glamapp/src/database"
+	"glamapp/src/media"
+	"glamapp/src/models"
+	"glamapp/src/storage"
+)
+
+const (
+	exportLease       = 10 * time.Minute
+	exportTimeout     = 9 * time.Minute
+	maxExportAttempts = 3
+)
+
+// ExportRunner builds the archives requested with database.CreateDataExport
+// and removes them again once they expire.
+type ExportRunner struct {
+	DB           *database.MongoDB
+	Blobs        storage.BlobStore
+	Logger       zerolog.Logger
+	PollInterval time.Duration
+	// TTL is how long a finished archive can be downloaded.
+	TTL time.Duration
+}
+
+func NewExportRunner(db *database.MongoDB, blobs storage.BlobStore, logger zerolog.Logger, ttl time.Duration) *ExportRunner {
+	return &ExportRunner{
+		DB:           db,
+		Blobs:        blobs,
+		Logger:       logger,
+		PollInterval: 5 * time.Second,
+		TTL:          ttl,
+	}
+}
+
+// Run processes exports until ctx is cancelled.
+func (r *ExportRunner) Run(ctx context.Context) {
+	poll(ctx, r.PollInterval, func() {
+		r.runPending()
+		r.purgeExpired()
+	})
+}
+
+func (r *ExportRunner) runPending() {
+	for {
+		export, err := r.DB.ClaimDataExport(time.Now().Add(exportLease))
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return
+		}
+		if err != nil {
+			r.Logger.Error().Err(err).Msg("Failed to claim data export")
+			return
+		}
+		r.run(export)
+	}
+}
+
+func (r *ExportRunner) run(export *models.DataExport) {
+	logger := r.Logger.With().Str("export_id", export.ID.Hex()).Str("user_id", export.UserID.Hex()).Logger()
+
+	// A worker that crashed half way leaves nothing behind but the lease, so
+	// an export that keeps coming back is given up on.
+	if export.Attempts > maxExportAttempts {
+		r.failExport(export, errors.New("too many attempts"), logger)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	key, err := exportKey(export.UserID)
+	if err != nil {
+		r.failExport(export, err, logger)
+		return
+	}
+
+	// The archive is written straight into the blob store so that neither it
+	// nor the user's data has to fit in memory.
+	pr, pw := io.Pipe()
+	archive := &countingWriter{w: pw}
+	done := make(chan error, 1)
+	go func() {
+		err := r.writeArchive(ctx, archive, export.UserID)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+	err = r.Blobs.PutStream(ctx, key, pr)
+	pr.CloseWithError(err)
+	if archiveErr := <-done; archiveErr != nil {
+		err = archiveErr
+	}
+	if err != nil {
+		if err := r.Blobs.Delete(ctx, key); err != nil {
+			logger.Error().Err(err).Msg("Failed to delete partial export archive")
+		}
+		r.failExport(export, err, logger)
+		return
+	}
+
+	if err := r.DB.FinishDataExport(export.ID, key, archive.n, time.Now().Add(r.TTL)); err != nil {
+		logger.Error().Err(err).Msg("Failed to finish data export")
+		if err := r.Blobs.Delete(ctx, key); err != nil {
+			logger.Error().Err(err).Msg("Failed to delete unused export archive")
+		}
+		return
+	}
+	logger.Info().Int64("size", archive.n).Msg("Data export ready")
+}
+
+func (r *ExportRunner) failExport(export *models.DataExport, exportErr error, logger zerolog.Logger) {
+	logger.Error().Err(exportErr).Msg("Data export failed")
+	if err := r.DB.FailDataExport(export.ID, exportErr); err != nil {
+		logger.Error().Err(err).Msg("Failed to mark data export as failed")
+	}
+}
+
+// writeArchive writes everything stored about the user to w as a ZIP file
+// with one JSON document per kind of data and the original avatar.
+func (r *ExportRunner) writeArchive(ctx context.Context, w io.Writer, userID primitive.ObjectID) error {
+	user, err := r.DB.GetUserByID(userID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch user: %w", err)
+	}
+
+	archive := zip.NewWriter(w)
+
+	profile := user.ToResponse("")
+	delete(profile, "avatar")
+	profile["email"] = user.Email
+	profile["two_factor_enabled"] = user.TOTPEnabled
+	if err := writeJSON(archive, "profile.json", profile); err != nil {
+		return err
+	}
+
+	if err := r.writeAvatar(ctx, archive, user.Avatar); err != nil {
+		return err
+	}
+
+	err = writeJSONArray(archive, "posts.json", func(emit func(interface{}) error) error {
+		return r.DB.ExportUserPosts(ctx, userID, func(post *models.Post) error { return emit(post) })
+	})
+	if err != nil {
+		return err
+	}
+
+	err = writeJSONArray(archive, "post_revisions.json", func(emit func(interface{}) error) error {
+		return r.DB.ExportUserPostRevisions(ctx, userID, func(revision *models.PostRevision) error { return emit(revision) })
+	})
+	if err != nil {
+		return err
+	}
+
+	err = writeJSONArray(archive, "comments.json", func(emit func(interface{}) error) error {
+		return r.DB.ExportUserComments(ctx, userID, func(comment *models.Comment) error { return emit(comment) })
+	})
+	if err != nil {
+		return err
+	}
+
+	err = writeJSONArray(archive, "likes.json", func(emit func(interface{}) error) error {
+		return r.DB.ExportUserLikes(ctx, userID, func(post *models.Post) error {
+			return emit(map[string]interface{}{
+				"post_id":   post.ID,
+				"author_id": post.AuthorID,
+				"content":   post.Content,
+			})
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	err = writeJSONArray(archive, "notifications.json", func(emit func(interface{}) error) error {
+		return r.DB.ExportUserNotifications(ctx, userID, func(notification *models.Notification) error { return emit(notification) })
+	})
+	if err != nil {
+		return err
+	}
+
+	err = writeJSONArray(archive, "sessions.json", func(emit func(interface{}) error) error {
+		return r.DB.ExportUserSessions(ctx, userID, func(session *models.Session) error {
+			return emit(session.ToResponse(primitive.NilObjectID))
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	err = writeJSONArray(archive, "history.json", func(emit func(interface{}) error) error {
+		return r.DB.ExportUserHistory(ctx, userID, func(entry *models.History) error { return emit(entry) })
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+	return nil
+}
+
+// writeAvatar adds the largest stored size of the avatar, if there is one.
+func (r *ExportRunner) writeAvatar(ctx context.Context, archive *zip.Writer, avatar *models.AvatarRef) error {
+	if avatar == nil {
+		return nil
+	}
+
+	largest := media.AvatarSizes[len(media.AvatarSizes)-1]
+	blob, err := r.Blobs.Open(ctx, avatar.Keys[strconv.Itoa(largest)])
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read avatar: %w", err)
+	}
+	defer blob.Close()
+
+	name := "avatar.png"
+	if avatar.ContentType == "image/jpeg" {
+		name = "avatar.jpg"
+	}
+	w, err := archive.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to add %s: %w", name, err)
+	}
+	if _, err := io.Copy(w, blob); err != nil {
+		return fmt.Errorf("failed to read avatar: %w", err)
+	}
+	return nil
+}
+
+// countingWriter keeps track of the size of the archive as it is written.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+func writeJSON(archive *zip.Writer, name string, v interface{}) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to add %s: %w", name, err)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// writeJSONArray writes every value passed to emit as one element of a JSON
+// array, without holding the whole list in memory.
+func writeJSONArray(archive *zip.Writer, name string, each func(emit func(interface{}) error) error) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to add %s: %w", name, err)
+	}
+
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	first := true
+	err = each(func(v interface{}) error {
+		data, err := json.MarshalIndent(v, "  ", "  ")
+		if err != nil {
+			return err
+		}
+		separator := ",\n  "
+		if first {
+			separator = "\n  "
+			first = false
+		}
+		if _, err := io.WriteString(w, separator); err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+
+	end := "\n]\n"
+	if first {
+		end = "]\n"
+	}
+	_, err = io.WriteString(w, end)
+	return err
+}
+
+func exportKey(userID primitive.ObjectID) (string, error) {
+	version := make([]byte, 16)
+	if _, err := rand.Read(version); err != nil {
+		return "", fmt.Errorf("failed to generate export key: %w", err)
+	}
+	return fmt.Sprintf("exports/%s/%s.zip", userID.Hex(), hex.EncodeToString(version)), nil
+}
+
+func (r *ExportRunner) purgeExpired() {
+	exports, err := r.DB.GetExpiredDataExports(time.Now())
+	if err != nil {
+		r.Logger.Error().Err(err).Msg("Failed to fetch expired data exports")
+		return
+	}
+
+	for _, export := range exports {
+		if err := deleteExport(context.Background(), r.DB, r.Blobs, &export); err != nil {
+			r.Logger.Error().Err(err).Str("export_id", export.ID.Hex()).Msg("Failed to delete expired data export")
+		}
+	}
+}
+
+func deleteExport(ctx context.Context, db *database.MongoDB, blobs storage.BlobStore, export *models.DataExport) error {
+	if export.BlobKey != "" {
+		if err := blobs.Delete(ctx, export.BlobKey); err != nil {
+			return err
+		}
+	}
+	return db.DeleteDataExport(export.ID)
+}
@@ -0,0 +1,25 @@
+// Package jobs runs long background work such as account deletion and data
+// exports. Jobs are stored in MongoDB and leased to one worker at a time, so
+// they survive restarts.
+package jobs
+
+import (
+	"context"
+	"time"
+)
+
+// poll calls fn right away and then every interval until ctx is cancelled.
+func poll(ctx context.Context, interval time.Duration, fn func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		fn()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
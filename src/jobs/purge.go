@@ -0,0 +1,65 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"glamapp/src/database"
+)
+
+const (
+	purgeBatchSize = 100
+	// purgeRetryDelay is how long a failed purge waits before it is queued
+	// again.
+	purgeRetryDelay = time.Hour
+)
+
+// DeactivationPurger queues the deletion of accounts that stayed deactivated
+// past their grace period and requeues purges that failed. The deletion
+// itself is done by DeletionRunner.
+type DeactivationPurger struct {
+	DB           *database.MongoDB
+	Logger       zerolog.Logger
+	PollInterval time.Duration
+}
+
+func NewDeactivationPurger(db *database.MongoDB, logger zerolog.Logger) *DeactivationPurger {
+	return &DeactivationPurger{
+		DB:           db,
+		Logger:       logger,
+		PollInterval: time.Minute,
+	}
+}
+
+// Run queues expired accounts until ctx is cancelled.
+func (p *DeactivationPurger) Run(ctx context.Context) {
+	poll(ctx, p.PollInterval, p.purgeExpired)
+}
+
+func (p *DeactivationPurger) purgeExpired() {
+	ids, err := p.DB.GetUsersToPurge(time.Now(), purgeBatchSize)
+	if err != nil {
+		p.Logger.Error().Err(err).Msg("Failed to fetch deactivated users to purge")
+		return
+	}
+
+	for _, id := range ids {
+		job, err := p.DB.QueueUserPurge(id, DeletionStepCount())
+		if err != nil {
+			p.Logger.Error().Err(err).Str("user_id", id.Hex()).Msg("Failed to queue deletion of deactivated user")
+			continue
+		}
+		p.Logger.Info().Str("user_id", id.Hex()).Str("job_id", job.ID.Hex()).Msg("Grace period over, deleting deactivated user")
+	}
+
+	requeued, err := p.DB.RequeueFailedPurges(time.Now().Add(-purgeRetryDelay))
+	if err != nil {
+		p.Logger.Error().Err(err).Msg("Failed to requeue failed purges")
+		return
+	}
+	if requeued > 0 {
+		p.Logger.Info().Int64("count", requeued).Msg("Requeued failed purges of deactivated users")
+	}
+}
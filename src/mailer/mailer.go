@@ -0,0 +1,65 @@
+// Package mailer delivers messages to users, such as password reset links.
+// The implementation is chosen through the mail_sender config option so
+// local development does not need a mail server.
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Sender interface {
+	Send(msg Message) error
+}
+
+// NewSender returns the sender configured by kind: "log" only records the
+// recipient and subject in the application log and "file" stores each
+// message in dir.
+func NewSender(kind, dir string, logger zerolog.Logger) (Sender, error) {
+	switch kind {
+	case "log":
+		return &LogSender{Logger: logger}, nil
+	case "file":
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create mail directory: %w", err)
+		}
+		return &FileSender{Dir: dir}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail sender %q", kind)
+	}
+}
+
+// LogSender leaves out the body, which carries reset and confirmation
+// tokens that must not end up in the logs.
+type LogSender struct {
+	Logger zerolog.Logger
+}
+
+func (s *LogSender) Send(msg Message) error {
+	s.Logger.Info().Str("to", msg.To).Str("subject", msg.Subject).Msg("Sending mail")
+	return nil
+}
+
+type FileSender struct {
+	Dir string
+}
+
+func (s *FileSender) Send(msg Message) error {
+	name := fmt.Sprintf("%d.eml", time.Now().UnixNano())
+	content := fmt.Sprintf("To: %s\r\nSubject: %s\r\n\r\n%s\r\n", msg.To, msg.Subject, msg.Body)
+
+	if err := os.WriteFile(filepath.Join(s.Dir, name), []byte(content), 0o600); err != nil {
+		return fmt.Errorf("failed to write mail: %w", err)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"time"
@@ -12,6 +13,13 @@ import (
 	"glamapp/src/api"
 	Config "glamapp/src/config"
 	"glamapp/src/database"
+	"glamapp/src/jobs"
+	"glamapp/src/media"
+	"glamapp/src/models"
+	"glamapp/src/oidc"
+	"glamapp/src/passwords"
+	"glamapp/src/storage"
+	"glamapp/src/tokens"
 )
 
 type App struct {
@@ -27,25 +35,79 @@ func main() {
 
 	config := Config.ReadConfig()
 
+	passwords.SetDefault(passwords.NewArgon2id(passwords.Argon2Params{
+		Memory:      config.Argon2Memory,
+		Iterations:  config.Argon2Iterations,
+		Parallelism: config.Argon2Parallelism,
+	}))
+
+	media.MaxAvatarBytes = config.AvatarMaxBytes
+
 	db := database.NewMongoDB(config.DatabaseURI, config.Database, logger)
 
+	if config.BootstrapAdmin != "" {
+		if err := db.SetUserRoleByName(config.BootstrapAdmin, models.RoleAdmin); err != nil {
+			logger.Warn().Err(err).Str("name", config.BootstrapAdmin).Msg("Failed to grant admin role to bootstrap admin")
+		}
+	}
+
+	blobs, err := storage.New(config.BlobStore, config.BlobDir, db.Database())
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to create blob store")
+	}
+
+	go func() {
+		migrated, err := db.MigrateAvatars(blobs)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to migrate avatars to the blob store")
+		}
+		if migrated > 0 {
+			logger.Info().Int("count", migrated).Msg("Migrated avatars to the blob store")
+		}
+	}()
+
+	go func() {
+		updated, err := db.BackfillSearchKeys()
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to backfill user search keys")
+		}
+		if updated > 0 {
+			logger.Info().Int("count", updated).Msg("Backfilled user search keys")
+		}
+	}()
+
+	go jobs.NewDeletionRunner(db, blobs, logger).Run(context.Background())
+	go jobs.NewExportRunner(db, blobs, logger, config.ExportTTL).Run(context.Background())
+	go jobs.NewDeactivationPurger(db, logger).Run(context.Background())
+
 	app := App{
-		App:    fiber.New(),
+		App: fiber.New(fiber.Config{
+			// Leave room for the rest of a multipart avatar upload.
+			BodyLimit: config.AvatarMaxBytes + 1<<20,
+		}),
 		DB:     db,
 		Logger: logger,
 	}
 
-	api.RegisterAuthRoutes(app.App, db, logger)
+	keys := loadKeys(config, logger)
+
+	api.RegisterWellKnownRoutes(app.App, keys)
+	providers := loadOIDCProviders(config)
+
+	api.RegisterAuthRoutes(app.App, db, keys, providers, logger)
 
 	apiV1 := app.Group("/api/v1")
 
-	apiV1.Use(api.JWTMiddleware(db, logger))
+	apiV1.Use(api.JWTMiddleware(db, keys, logger))
 
 	apiV1.Use(api.SessionMiddleware(db, logger))
 
-	api.RegisterProfileRoutes(apiV1, db, logger)
+	api.RegisterProfileRoutes(apiV1, db, blobs, logger)
 	api.RegisterPostRoutes(apiV1, db, logger)
 	api.RegisterNotificationRoutes(apiV1, db, logger)
+	api.RegisterSessionRoutes(apiV1, db, logger)
+	api.RegisterAccessTokenRoutes(apiV1, db, logger)
+	api.RegisterIdentityRoutes(apiV1, db, providers, logger)
 
 	address := fmt.Sprintf("%s:%s", config.AppHost, config.AppPort)
 	logger.Info().Msgf("Starting server on %s", address)
@@ -53,3 +115,35 @@ func main() {
 		logger.Fatal().Err(err).Msg("Failed to start server")
 	}
 }
+
+func loadKeys(config *Config.Config, logger zerolog.Logger) *tokens.KeySet {
+	if config.JWTKeysDir == "" {
+		logger.Warn().Msg("jwt_ephemeral_keys is set, signing tokens with a key that changes on every start")
+		keys, err := tokens.NewEphemeralKeySet(config.JWTIssuer)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Failed to generate signing key")
+		}
+		return keys
+	}
+
+	keys, err := tokens.LoadKeySet(config.JWTKeysDir, config.JWTSigningKID, config.JWTIssuer)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to load signing keys")
+	}
+	return keys
+}
+
+func loadOIDCProviders(config *Config.Config) map[string]*oidc.Provider {
+	providers := map[string]*oidc.Provider{}
+	for _, provider := range config.OIDCProviders {
+		providers[provider.Name] = oidc.NewProvider(oidc.Config{
+			Name:         provider.Name,
+			Issuer:       provider.Issuer,
+			ClientID:     provider.ClientID,
+			ClientSecret: provider.ClientSecret,
+			RedirectURL:  provider.RedirectURL,
+			Scopes:       provider.Scopes,
+		})
+	}
+	return providers
+}
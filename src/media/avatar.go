@@ -0,0 +1,152 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// AvatarSizes are the square sizes generated for every avatar, in pixels.
+var AvatarSizes = []int{64, 256, 512}
+
+// DefaultAvatarSize is served when no size is requested.
+const DefaultAvatarSize = 256
+
+// MaxAvatarBytes limits uploaded avatar files. It is set from config on
+// startup.
+var MaxAvatarBytes = 5 << 20
+
+// maxAvatarPixels guards against images that are small on disk but huge once
+// decoded.
+const maxAvatarPixels = 40_000_000
+
+var (
+	ErrAvatarTooLarge      = errors.New("avatar file is too large")
+	ErrUnsupportedFormat   = errors.New("avatar must be a JPEG, PNG, WebP or GIF image")
+	ErrAvatarTooManyPixels = errors.New("avatar dimensions are too large")
+)
+
+// allowedTypes are detected from the file content, never from the
+// Content-Type the client sent.
+var allowedTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+	"image/gif":  true,
+}
+
+// Avatar is a processed avatar. Every size is re-encoded from the decoded
+// pixels, so no metadata such as EXIF or GPS data of the upload survives.
+type Avatar struct {
+	ContentType string
+	Sizes       map[int][]byte
+}
+
+// DetectType returns the image type of data based on its magic bytes, or ""
+// if it is not one of the allowed formats.
+func DetectType(data []byte) string {
+	contentType := http.DetectContentType(data)
+	if !allowedTypes[contentType] {
+		return ""
+	}
+	return contentType
+}
+
+// ProcessAvatar validates an uploaded image and renders it as square JPEG
+// or PNG images in every AvatarSizes size. JPEG uploads stay JPEG, other
+// formats become PNG to keep transparency.
+func ProcessAvatar(data []byte) (*Avatar, error) {
+	if len(data) > MaxAvatarBytes {
+		return nil, ErrAvatarTooLarge
+	}
+
+	contentType := DetectType(data)
+	if contentType == "" {
+		return nil, ErrUnsupportedFormat
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read avatar: %w", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxAvatarPixels {
+		return nil, ErrAvatarTooManyPixels
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode avatar: %w", err)
+	}
+	src = cropSquare(src)
+
+	avatar := &Avatar{ContentType: "image/png", Sizes: make(map[int][]byte, len(AvatarSizes))}
+	if contentType == "image/jpeg" {
+		avatar.ContentType = "image/jpeg"
+	}
+
+	for _, size := range AvatarSizes {
+		encoded, err := encode(resize(src, size), avatar.ContentType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode avatar: %w", err)
+		}
+		avatar.Sizes[size] = encoded
+	}
+
+	return avatar, nil
+}
+
+// ClosestSize returns the smallest generated size that is at least size, or
+// the largest one.
+func ClosestSize(size int) int {
+	for _, s := range AvatarSizes {
+		if s >= size {
+			return s
+		}
+	}
+	return AvatarSizes[len(AvatarSizes)-1]
+}
+
+func cropSquare(img image.Image) image.Image {
+	bounds := img.Bounds()
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+
+	x := bounds.Min.X + (bounds.Dx()-side)/2
+	y := bounds.Min.Y + (bounds.Dy()-side)/2
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), img, image.Pt(x, y), draw.Src)
+	return square
+}
+
+// resize scales a square image down to size. Smaller images are not scaled
+// up.
+func resize(img image.Image, size int) image.Image {
+	if img.Bounds().Dx() <= size {
+		return img
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+func encode(img image.Image, contentType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	return buf.Bytes(), err
+}
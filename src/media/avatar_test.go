@@ -0,0 +1,196 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func testImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDetectType(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"png", encodePNG(t, testImage(4, 4)), "image/png"},
+		{"jpeg", encodeJPEG(t, testImage(4, 4)), "image/jpeg"},
+		{"gif", []byte("GIF89a\x01\x00\x01\x00"), "image/gif"},
+		{"html", []byte("<!DOCTYPE html><html><script>alert(1)</script></html>"), ""},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg" onload="alert(1)"/>`), ""},
+		{"text", []byte("just text"), ""},
+		{"empty", nil, ""},
+	}
+
+	for _, tt := range tests {
+		if got := DetectType(tt.data); got != tt.want {
+			t.Errorf("DetectType(%s) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProcessAvatarRejectsNonImages(t *testing.T) {
+	tests := map[string][]byte{
+		"html":          []byte("<html><body><script>alert(1)</script></body></html>"),
+		"gif polyglot":  []byte("GIF89a/*<script>alert(1)</script>*/"),
+		"truncated png": encodePNG(t, testImage(16, 16))[:40],
+	}
+
+	for name, data := range tests {
+		if _, err := ProcessAvatar(data); err == nil {
+			t.Errorf("ProcessAvatar(%s) accepted the upload", name)
+		}
+	}
+}
+
+func TestProcessAvatarDropsTrailingPayload(t *testing.T) {
+	payload := []byte("<html><script>alert(1)</script></html>")
+	data := append(encodePNG(t, testImage(32, 32)), payload...)
+
+	avatar, err := ProcessAvatar(data)
+	if err != nil {
+		t.Fatalf("ProcessAvatar: %v", err)
+	}
+	for size, encoded := range avatar.Sizes {
+		if bytes.Contains(encoded, []byte("<script>")) {
+			t.Errorf("size %d still contains the appended payload", size)
+		}
+	}
+}
+
+func TestProcessAvatarStripsEXIF(t *testing.T) {
+	jpg := encodeJPEG(t, testImage(300, 200))
+	// Insert an APP1 segment with EXIF GPS data right after the SOI marker.
+	exif := append([]byte("Exif\x00\x00"), []byte("GPSLatitude 52.5200 GPSLongitude 13.4050")...)
+	segment := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(exif)+2))
+	data := append(append(append([]byte{}, jpg[:2]...), append(segment, exif...)...), jpg[2:]...)
+
+	avatar, err := ProcessAvatar(data)
+	if err != nil {
+		t.Fatalf("ProcessAvatar: %v", err)
+	}
+	if avatar.ContentType != "image/jpeg" {
+		t.Errorf("ContentType = %q, want image/jpeg", avatar.ContentType)
+	}
+	for size, encoded := range avatar.Sizes {
+		if bytes.Contains(encoded, []byte("Exif")) || bytes.Contains(encoded, []byte("GPSLatitude")) {
+			t.Errorf("size %d still contains EXIF data", size)
+		}
+	}
+}
+
+func TestProcessAvatarSizes(t *testing.T) {
+	tests := []struct {
+		name          string
+		data          []byte
+		contentType   string
+		wantDimension map[int]int
+	}{
+		{
+			name:          "large png",
+			data:          encodePNG(t, testImage(800, 600)),
+			contentType:   "image/png",
+			wantDimension: map[int]int{64: 64, 256: 256, 512: 512},
+		},
+		{
+			// Small images are cropped but not scaled up.
+			name:          "small jpeg",
+			data:          encodeJPEG(t, testImage(100, 120)),
+			contentType:   "image/jpeg",
+			wantDimension: map[int]int{64: 64, 256: 100, 512: 100},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			avatar, err := ProcessAvatar(tt.data)
+			if err != nil {
+				t.Fatalf("ProcessAvatar: %v", err)
+			}
+			if avatar.ContentType != tt.contentType {
+				t.Errorf("ContentType = %q, want %q", avatar.ContentType, tt.contentType)
+			}
+			for size, want := range tt.wantDimension {
+				config, _, err := image.DecodeConfig(bytes.NewReader(avatar.Sizes[size]))
+				if err != nil {
+					t.Fatalf("size %d: %v", size, err)
+				}
+				if config.Width != want || config.Height != want {
+					t.Errorf("size %d is %dx%d, want %dx%d", size, config.Width, config.Height, want, want)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessAvatarLimits(t *testing.T) {
+	previous := MaxAvatarBytes
+	MaxAvatarBytes = 1024
+	t.Cleanup(func() { MaxAvatarBytes = previous })
+
+	if _, err := ProcessAvatar(make([]byte, 1025)); !errors.Is(err, ErrAvatarTooLarge) {
+		t.Errorf("oversized upload error = %v, want ErrAvatarTooLarge", err)
+	}
+
+	// A tiny PNG whose header claims 10000x10000 pixels.
+	data := encodePNG(t, testImage(1, 1))
+	binary.BigEndian.PutUint32(data[16:], 10000)
+	binary.BigEndian.PutUint32(data[20:], 10000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	if _, err := ProcessAvatar(data); !errors.Is(err, ErrAvatarTooManyPixels) {
+		t.Errorf("decompression bomb error = %v, want ErrAvatarTooManyPixels", err)
+	}
+}
+
+func TestClosestSize(t *testing.T) {
+	tests := []struct{ size, want int }{
+		{0, 64},
+		{1, 64},
+		{64, 64},
+		{65, 256},
+		{256, 256},
+		{300, 512},
+		{512, 512},
+		{4096, 512},
+	}
+
+	for _, tt := range tests {
+		if got := ClosestSize(tt.size); got != tt.want {
+			t.Errorf("ClosestSize(%d) = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
@@ -1,60 +1,426 @@
 package models
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"golang.org/x/crypto/bcrypt"
+
+	"glamapp/src/media"
+	"glamapp/src/passwords"
+)
+
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
 )
 
+var roleRanks = map[Role]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+func (r Role) Valid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
 type User struct {
 	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
 	Name         string             `bson:"name" json:"name"`
+	Email        string             `bson:"email,omitempty" json:"-"`
+	Role         Role               `bson:"role" json:"role"`
 	PasswordHash string             `bson:"password_hash" json:"-"`
-	AvatarData   []byte             `bson:"avatar_data" json:"-"`
-	AvatarType   string             `bson:"avatar_type" json:"-"`
+	Avatar       *AvatarRef         `bson:"avatar,omitempty" json:"-"`
 	Posts        []string           `bson:"posts" json:"posts"`
 	LikedPosts   []string           `bson:"liked_posts" json:"liked_posts"`
 	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
 	UpdatedAt    time.Time          `bson:"updated_at" json:"updated_at"`
+
+	// Follow relationships live in their own collection, only the counts are
+	// kept on the user.
+	FollowersCount int64 `bson:"followers_count" json:"followers_count"`
+	FollowingCount int64 `bson:"following_count" json:"following_count"`
+
+	DisplayName string        `bson:"display_name,omitempty" json:"display_name"`
+	Bio         string        `bson:"bio,omitempty" json:"bio"`
+	Website     string        `bson:"website,omitempty" json:"website"`
+	Pronouns    string        `bson:"pronouns,omitempty" json:"pronouns"`
+	Location    string        `bson:"location,omitempty" json:"location"`
+	Links       []ProfileLink `bson:"links,omitempty" json:"links"`
+
+	// Private accounts approve their followers and only show posts to them.
+	Private bool `bson:"private" json:"private"`
+
+	TOTPSecret    string   `bson:"totp_secret,omitempty" json:"-"`
+	TOTPEnabled   bool     `bson:"totp_enabled" json:"-"`
+	TOTPLastStep  int64    `bson:"totp_last_step" json:"-"`
+	RecoveryCodes []string `bson:"recovery_codes,omitempty" json:"-"`
+
+	// A deactivated account is hidden and can be restored by logging in
+	// until PurgeAt, when it is deleted for good.
+	DeactivatedAt *time.Time `bson:"deactivated_at,omitempty" json:"-"`
+	PurgeAt       *time.Time `bson:"purge_at,omitempty" json:"-"`
+
+	// Lowercase copies of the names that user search matches with indexed
+	// prefix queries, kept up to date by SetSearchKeys.
+	NameLower        string   `bson:"name_lower" json:"-"`
+	DisplayNameWords []string `bson:"display_name_words,omitempty" json:"-"`
+
+	// PendingAvatar is a processed upload that still has to be stored.
+	PendingAvatar *media.Avatar `bson:"-" json:"-"`
+}
+
+// AvatarRef points to the avatar images in the blob store. Keys maps the
+// size in pixels to the blob key.
+type AvatarRef struct {
+	ContentType string            `bson:"content_type"`
+	Keys        map[string]string `bson:"keys"`
+	UpdatedAt   time.Time         `bson:"updated_at"`
+}
+
+// Key returns the blob key of the generated size closest to size.
+func (a *AvatarRef) Key(size int) string {
+	return a.Keys[strconv.Itoa(media.ClosestSize(size))]
+}
+
+const (
+	MinUsernameLength = 3
+	MaxUsernameLength = 30
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.]+$`)
+
+// reservedUsernames would be confusing or collide with routes such as
+// /users/me.
+var reservedUsernames = map[string]bool{
+	"me": true, "admin": true, "administrator": true, "root": true, "system": true,
+	"moderator": true, "support": true, "help": true, "glamapp": true, "api": true,
+	"auth": true, "login": true, "logout": true, "register": true, "settings": true,
+	"search": true, "users": true, "posts": true, "notifications": true,
+	"sessions": true, "tokens": true, "null": true, "undefined": true,
+}
+
+// ValidateUsername checks the rules every user name has to follow. Names are
+// unique regardless of case.
+func ValidateUsername(name string) error {
+	if len(name) < MinUsernameLength || len(name) > MaxUsernameLength {
+		return fmt.Errorf("name must be between %d and %d characters", MinUsernameLength, MaxUsernameLength)
+	}
+	if !usernamePattern.MatchString(name) {
+		return fmt.Errorf("name may only contain letters, digits, underscores and dots")
+	}
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") || strings.Contains(name, "..") {
+		return fmt.Errorf("name may not start or end with a dot or contain consecutive dots")
+	}
+	if reservedUsernames[strings.ToLower(name)] {
+		return fmt.Errorf("name %q is reserved", name)
+	}
+	return nil
 }
 
 func NewUser() *User {
 	return &User{
+		Role:       RoleUser,
 		Posts:      []string{},
 		LikedPosts: []string{},
 	}
 }
 
+// Deactivated reports whether the account was deactivated and is waiting to
+// be restored or purged.
+func (u *User) Deactivated() bool {
+	return u.DeactivatedAt != nil
+}
+
+// EffectiveRole treats users created before roles existed as regular users.
+func (u *User) EffectiveRole() Role {
+	if !u.Role.Valid() {
+		return RoleUser
+	}
+	return u.Role
+}
+
+// HasRole reports whether the user holds role or a more privileged one.
+func (u *User) HasRole(role Role) bool {
+	return roleRanks[u.EffectiveRole()] >= roleRanks[role]
+}
+
+// CanModify reports whether the user may change a resource owned by ownerID.
+// Owners always can; anybody else needs at least the override role.
+func (u *User) CanModify(ownerID primitive.ObjectID, override Role) bool {
+	return u.ID == ownerID || u.HasRole(override)
+}
+
+// SetSearchKeys derives NameLower and DisplayNameWords from the names. The
+// display name is stored from each of its words on, so a prefix of any
+// element matches the start of a word.
+func (u *User) SetSearchKeys() {
+	u.NameLower = strings.ToLower(u.Name)
+	u.DisplayNameWords = nil
+	words := strings.Fields(strings.ToLower(u.DisplayName))
+	for i := range words {
+		u.DisplayNameWords = append(u.DisplayNameWords, strings.Join(words[i:], " "))
+	}
+}
+
 func (u *User) SetPassword(password string) error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := passwords.Hash(password)
 	if err != nil {
 		return err
 	}
-	u.PasswordHash = string(hashedPassword)
+	u.PasswordHash = hashedPassword
 	return nil
 }
 
 func (u *User) CheckPassword(password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password))
-	return err == nil
+	ok, err := passwords.Verify(password, u.PasswordHash)
+	return err == nil && ok
+}
+
+// PasswordNeedsRehash reports whether the stored hash uses bcrypt or outdated
+// Argon2id parameters and should be replaced after the next successful login.
+func (u *User) PasswordNeedsRehash() bool {
+	return u.PasswordHash != "" && passwords.NeedsRehash(u.PasswordHash)
 }
 
 func (u *User) ToResponse(baseURL string) map[string]interface{} {
+	links := u.Links
+	if links == nil {
+		links = []ProfileLink{}
+	}
+
 	return map[string]interface{}{
 		"id":          u.ID,
 		"name":        u.Name,
+		"role":        u.EffectiveRole(),
 		"avatar":      baseURL + "/api/v1/users/" + u.ID.Hex() + "/avatar",
 		"posts":       u.Posts,
 		"liked_posts": u.LikedPosts,
 		"created_at":  u.CreatedAt,
 		"updated_at":  u.UpdatedAt,
+
+		"followers_count": u.FollowersCount,
+		"following_count": u.FollowingCount,
+
+		"display_name": u.DisplayName,
+		"bio":          u.Bio,
+		"website":      u.Website,
+		"pronouns":     u.Pronouns,
+		"location":     u.Location,
+		"links":        links,
+
+		"private": u.Private,
 	}
 }
 
+const (
+	MaxDisplayNameLength = 50
+	MaxBioLength         = 300
+	MaxPronounsLength    = 30
+	MaxLocationLength    = 100
+	MaxURLLength         = 200
+	MaxLinkLabelLength   = 30
+	MaxProfileLinks      = 5
+)
+
+// ProfileLink is an additional link shown on a profile.
+type ProfileLink struct {
+	Label string `bson:"label" json:"label"`
+	URL   string `bson:"url" json:"url"`
+}
+
+// applyProfile validates the optional profile fields of input and copies
+// them to the user.
+func (u *User) applyProfile(input *userInput, updatedFields map[string]bool) error {
+	texts := []struct {
+		field     string
+		value     *string
+		target    *string
+		maxLength int
+		multiline bool
+	}{
+		{"display_name", input.DisplayName, &u.DisplayName, MaxDisplayNameLength, false},
+		{"bio", input.Bio, &u.Bio, MaxBioLength, true},
+		{"pronouns", input.Pronouns, &u.Pronouns, MaxPronounsLength, false},
+		{"location", input.Location, &u.Location, MaxLocationLength, false},
+	}
+	for _, text := range texts {
+		if text.value == nil {
+			continue
+		}
+		value := sanitizeText(*text.value, text.multiline)
+		if utf8.RuneCountInString(value) > text.maxLength {
+			return fmt.Errorf("%s must be at most %d characters", text.field, text.maxLength)
+		}
+		*text.target = value
+		updatedFields[text.field] = true
+	}
+
+	if input.Website != nil {
+		website, err := sanitizeURL(*input.Website)
+		if err != nil {
+			return fmt.Errorf("invalid website: %w", err)
+		}
+		u.Website = website
+		updatedFields["website"] = true
+	}
+
+	if input.Links != nil {
+		if len(*input.Links) > MaxProfileLinks {
+			return fmt.Errorf("at most %d links are allowed", MaxProfileLinks)
+		}
+		links := make([]ProfileLink, 0, len(*input.Links))
+		for _, link := range *input.Links {
+			label := sanitizeText(link.Label, false)
+			if label == "" || utf8.RuneCountInString(label) > MaxLinkLabelLength {
+				return fmt.Errorf("link labels must be between 1 and %d characters", MaxLinkLabelLength)
+			}
+			address, err := sanitizeURL(link.URL)
+			if err != nil || address == "" {
+				return fmt.Errorf("invalid url for link %q", label)
+			}
+			links = append(links, ProfileLink{Label: label, URL: address})
+		}
+		u.Links = links
+		updatedFields["links"] = true
+	}
+
+	if input.Private != nil {
+		u.Private = *input.Private
+		updatedFields["private"] = true
+	}
+
+	return nil
+}
+
+// sanitizeText trims s and drops control and invisible formatting
+// characters. Newlines are only kept when multiline is set.
+func sanitizeText(s string, multiline bool) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '\n' && multiline {
+			return r
+		}
+		if r == '\t' {
+			return ' '
+		}
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return r
+	}, s)
+	return strings.TrimSpace(s)
+}
+
+// sanitizeURL accepts http and https URLs, adding https:// when no scheme is
+// given. An empty string clears the field.
+func sanitizeURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", nil
+	}
+	if len(raw) > MaxURLLength {
+		return "", fmt.Errorf("must be at most %d characters", MaxURLLength)
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return "", fmt.Errorf("only http and https links are allowed")
+	}
+	if parsed.Host == "" || parsed.User != nil {
+		return "", fmt.Errorf("must be a link to a website")
+	}
+	return parsed.String(), nil
+}
+
+// ParseEmail validates an email address and returns it without a display
+// name.
+func ParseEmail(value string) (string, error) {
+	address, err := mail.ParseAddress(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid email: %w", err)
+	}
+	return address.Address, nil
+}
+
+// userInput holds the fields of a create or update request. Nil fields were
+// not sent.
+type userInput struct {
+	Name        *string        `json:"name"`
+	Email       *string        `json:"email"`
+	Password    *string        `json:"password"`
+	DisplayName *string        `json:"display_name"`
+	Bio         *string        `json:"bio"`
+	Website     *string        `json:"website"`
+	Pronouns    *string        `json:"pronouns"`
+	Location    *string        `json:"location"`
+	Links       *[]ProfileLink `json:"links"`
+	Private     *bool          `json:"private"`
+}
+
+// readUserInput reads a JSON body, or form values otherwise. Empty form
+// values count as not sent, so clearing a field needs a JSON request.
+func readUserInput(c *fiber.Ctx) (*userInput, error) {
+	input := new(userInput)
+	if c.Is("json") {
+		if err := json.Unmarshal(c.Body(), input); err != nil {
+			return nil, fmt.Errorf("invalid JSON body: %w", err)
+		}
+		return input, nil
+	}
+
+	formValue := func(key string) *string {
+		if value := c.FormValue(key); value != "" {
+			return &value
+		}
+		return nil
+	}
+	input.Name = formValue("name")
+	input.Email = formValue("email")
+	input.Password = formValue("password")
+	input.DisplayName = formValue("display_name")
+	input.Bio = formValue("bio")
+	input.Website = formValue("website")
+	input.Pronouns = formValue("pronouns")
+	input.Location = formValue("location")
+	if links := formValue("links"); links != nil {
+		input.Links = new([]ProfileLink)
+		if err := json.Unmarshal([]byte(*links), input.Links); err != nil {
+			return nil, fmt.Errorf("links must be a JSON array: %w", err)
+		}
+	}
+	if private := formValue("private"); private != nil {
+		value, err := strconv.ParseBool(*private)
+		if err != nil {
+			return nil, fmt.Errorf("private must be true or false")
+		}
+		input.Private = &value
+	}
+	return input, nil
+}
+
 func (u *User) Parse(c *fiber.Ctx, isUpdate bool) (map[string]bool, error) {
 	updatedFields := make(map[string]bool)
 
@@ -62,15 +428,39 @@ func (u *User) Parse(c *fiber.Ctx, isUpdate bool) (map[string]bool, error) {
 		u.ID = primitive.NewObjectID()
 	}
 
-	if name := c.FormValue("name"); name != "" {
+	input, err := readUserInput(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.Name != nil {
+		name := strings.TrimSpace(*input.Name)
+		if err := ValidateUsername(name); err != nil {
+			return nil, err
+		}
 		u.Name = name
 		updatedFields["name"] = true
 	} else if !isUpdate {
 		return nil, fmt.Errorf("name is required for new users")
 	}
 
-	if password := c.FormValue("password"); password != "" {
-		if err := u.SetPassword(password); err != nil {
+	if input.Email != nil {
+		if isUpdate {
+			return nil, fmt.Errorf("email can only be changed through /users/me/email")
+		}
+		address, err := ParseEmail(*input.Email)
+		if err != nil {
+			return nil, err
+		}
+		u.Email = address
+		updatedFields["email"] = true
+	}
+
+	if input.Password != nil {
+		if isUpdate {
+			return nil, fmt.Errorf("password can only be changed through /users/me/password")
+		}
+		if err := u.SetPassword(*input.Password); err != nil {
 			return nil, fmt.Errorf("failed to set password: %w", err)
 		}
 		updatedFields["password_hash"] = true
@@ -78,7 +468,12 @@ func (u *User) Parse(c *fiber.Ctx, isUpdate bool) (map[string]bool, error) {
 		return nil, fmt.Errorf("password is required for new users")
 	}
 
-	if isUpdate {
+	if err := u.applyProfile(input, updatedFields); err != nil {
+		return nil, err
+	}
+
+	isMultipart := strings.HasPrefix(string(c.Request().Header.ContentType()), fiber.MIMEMultipartForm)
+	if isUpdate && isMultipart {
 		file, err := c.FormFile("avatar")
 		if err == nil {
 			src, err := file.Open()
@@ -87,21 +482,27 @@ func (u *User) Parse(c *fiber.Ctx, isUpdate bool) (map[string]bool, error) {
 			}
 			defer src.Close()
 
-			avatarData, err := io.ReadAll(src)
+			if file.Size > int64(media.MaxAvatarBytes) {
+				return nil, fmt.Errorf("avatar must be at most %d bytes", media.MaxAvatarBytes)
+			}
+			avatarData, err := io.ReadAll(io.LimitReader(src, int64(media.MaxAvatarBytes)+1))
 			if err != nil {
 				return nil, fmt.Errorf("failed to read avatar file: %w", err)
 			}
 
-			u.AvatarData = avatarData
-			u.AvatarType = file.Header.Get("Content-Type")
-			updatedFields["avatar_data"] = true
-			updatedFields["avatar_type"] = true
-		} else if err != fiber.ErrUnprocessableEntity {
+			// The uploaded bytes and Content-Type are never stored, only the
+			// re-encoded images.
+			avatar, err := media.ProcessAvatar(avatarData)
+			if err != nil {
+				return nil, err
+			}
+
+			// The handler stores the images and fills in Avatar.
+			u.PendingAvatar = avatar
+			updatedFields["avatar"] = true
+		} else if !errors.Is(err, fasthttp.ErrMissingFile) {
 			return nil, fmt.Errorf("failed to process avatar file: %w", err)
 		}
-	} else {
-		u.AvatarData = nil
-		u.AvatarType = ""
 	}
 
 	now := time.Now()
@@ -114,19 +515,228 @@ func (u *User) Parse(c *fiber.Ctx, isUpdate bool) (map[string]bool, error) {
 	return updatedFields, nil
 }
 
+// Session is a refresh token family created on login. Token holds the hash of
+// the current refresh token and PreviousTokens the hashes of every token it
+// replaced, so presenting a rotated token again revokes the whole session.
 type Session struct {
-	ID           primitive.ObjectID `bson:"_id,omitempty"`
-	UserID       primitive.ObjectID `bson:"user_id"`
-	Token        string             `bson:"token"`
-	CreatedAt    time.Time          `bson:"created_at"`
-	ExpiresAt    time.Time          `bson:"expires_at"`
-	LastActivity time.Time          `bson:"last_activity"`
+	ID             primitive.ObjectID `bson:"_id,omitempty"`
+	UserID         primitive.ObjectID `bson:"user_id"`
+	Token          string             `bson:"token"`
+	PreviousTokens []string           `bson:"previous_tokens"`
+	UserAgent      string             `bson:"user_agent"`
+	IP             string             `bson:"ip"`
+	DeviceName     string             `bson:"device_name"`
+	CreatedAt      time.Time          `bson:"created_at"`
+	ExpiresAt      time.Time          `bson:"expires_at"`
+	LastActivity   time.Time          `bson:"last_activity"`
 }
 
-type History struct {
+func (s *Session) ToResponse(currentSessionID primitive.ObjectID) map[string]interface{} {
+	return map[string]interface{}{
+		"id":            s.ID,
+		"user_agent":    s.UserAgent,
+		"ip":            s.IP,
+		"device_name":   s.DeviceName,
+		"current":       s.ID == currentSessionID,
+		"created_at":    s.CreatedAt,
+		"last_activity": s.LastActivity,
+		"expires_at":    s.ExpiresAt,
+	}
+}
+
+// DeviceName builds a rough, human readable description such as
+// "Chrome on Android" from a User-Agent header.
+func DeviceName(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	platform := ""
+	switch {
+	case strings.Contains(ua, "iphone"):
+		platform = "iPhone"
+	case strings.Contains(ua, "ipad"):
+		platform = "iPad"
+	case strings.Contains(ua, "android"):
+		platform = "Android"
+	case strings.Contains(ua, "windows"):
+		platform = "Windows"
+	case strings.Contains(ua, "mac os"), strings.Contains(ua, "macintosh"):
+		platform = "macOS"
+	case strings.Contains(ua, "linux"):
+		platform = "Linux"
+	}
+
+	client := ""
+	switch {
+	case strings.Contains(ua, "edg/"):
+		client = "Edge"
+	case strings.Contains(ua, "opr/"), strings.Contains(ua, "opera"):
+		client = "Opera"
+	case strings.Contains(ua, "firefox/"):
+		client = "Firefox"
+	case strings.Contains(ua, "chrome/"), strings.Contains(ua, "crios/"):
+		client = "Chrome"
+	case strings.Contains(ua, "safari/"):
+		client = "Safari"
+	case strings.Contains(ua, "python-requests"):
+		client = "Python client"
+	case strings.HasPrefix(ua, "curl/"):
+		client = "curl"
+	}
+
+	switch {
+	case client != "" && platform != "":
+		return client + " on " + platform
+	case client != "":
+		return client
+	case platform != "":
+		return platform
+	default:
+		return "Unknown device"
+	}
+}
+
+// PasswordReset is a single-use token sent to users who forgot their
+// password. Only the hash of the token is stored.
+type PasswordReset struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	TokenHash string             `bson:"token_hash"`
+	CreatedAt time.Time          `bson:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+	UsedAt    *time.Time         `bson:"used_at"`
+}
+
+// EmailChange is a single-use token sent to a new email address to confirm
+// it belongs to the user. Only the hash of the token is stored.
+type EmailChange struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty"`
 	UserID    primitive.ObjectID `bson:"user_id"`
-	Timestamp time.Time          `bson:"timestamp"`
+	Email     string             `bson:"email"`
+	TokenHash string             `bson:"token_hash"`
+	CreatedAt time.Time          `bson:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+	UsedAt    *time.Time         `bson:"used_at"`
+}
+
+// LoginAttempt counts consecutive failed logins for a key such as an account
+// name or a client IP.
+type LoginAttempt struct {
+	Key         string    `bson:"_id"`
+	Failures    int       `bson:"failures"`
+	LockedUntil time.Time `bson:"locked_until"`
+	UpdatedAt   time.Time `bson:"updated_at"`
+}
+
+type Scope string
+
+const (
+	ScopeUsersRead         Scope = "users:read"
+	ScopeUsersWrite        Scope = "users:write"
+	ScopePostsRead         Scope = "posts:read"
+	ScopePostsWrite        Scope = "posts:write"
+	ScopeNotificationsRead Scope = "notifications:read"
+)
+
+var Scopes = []Scope{
+	ScopeUsersRead,
+	ScopeUsersWrite,
+	ScopePostsRead,
+	ScopePostsWrite,
+	ScopeNotificationsRead,
+}
+
+func (s Scope) Valid() bool {
+	for _, scope := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// AccessToken is a named personal access token for scripts and integrations.
+// Only a hash of the token is stored; Prefix keeps its first characters so
+// users can tell their tokens apart.
+type AccessToken struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	UserID     primitive.ObjectID `bson:"user_id"`
+	Name       string             `bson:"name"`
+	Prefix     string             `bson:"prefix"`
+	TokenHash  string             `bson:"token_hash"`
+	Scopes     []Scope            `bson:"scopes"`
+	CreatedAt  time.Time          `bson:"created_at"`
+	ExpiresAt  *time.Time         `bson:"expires_at,omitempty"`
+	LastUsedAt *time.Time         `bson:"last_used_at,omitempty"`
+}
+
+func (t *AccessToken) HasScope(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *AccessToken) Expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+func (t *AccessToken) ToResponse() map[string]interface{} {
+	return map[string]interface{}{
+		"id":           t.ID,
+		"name":         t.Name,
+		"prefix":       t.Prefix,
+		"scopes":       t.Scopes,
+		"created_at":   t.CreatedAt,
+		"expires_at":   t.ExpiresAt,
+		"last_used_at": t.LastUsedAt,
+	}
+}
+
+// OIDCState remembers an authorization request to an OpenID Connect
+// provider until the user comes back to the callback. UserID is set when an
+// already logged in user links a new identity. BrowserHash is the hash of a
+// cookie set on the browser that started the flow, so the callback cannot be
+// completed in another browser. Link states get it once the link URL is
+// opened in a browser.
+type OIDCState struct {
+	ID           string              `bson:"_id"`
+	Provider     string              `bson:"provider"`
+	Nonce        string              `bson:"nonce"`
+	CodeVerifier string              `bson:"code_verifier"`
+	BrowserHash  string              `bson:"browser_hash"`
+	UserID       *primitive.ObjectID `bson:"user_id,omitempty"`
+	Reactivate   bool                `bson:"reactivate,omitempty"`
+	CreatedAt    time.Time           `bson:"created_at"`
+	ExpiresAt    time.Time           `bson:"expires_at"`
+}
+
+// Identity links a user to an account at an external OpenID Connect
+// provider, identified by the provider's subject.
+type Identity struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"-"`
+	Provider  string             `bson:"provider" json:"provider"`
+	Subject   string             `bson:"subject" json:"subject"`
+	Email     string             `bson:"email,omitempty" json:"email,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// ToSummary returns the few fields needed to show the user in a list.
+func (u *User) ToSummary(baseURL string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           u.ID,
+		"name":         u.Name,
+		"display_name": u.DisplayName,
+		"avatar":       baseURL + "/api/v1/users/" + u.ID.Hex() + "/avatar",
+	}
+}
+
+type History struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"-"`
+	Timestamp time.Time          `bson:"timestamp" json:"timestamp"`
 }
 
 type Post struct {
@@ -134,52 +744,318 @@ type Post struct {
 	Content    string             `json:"content" bson:"content"`
 	AuthorID   primitive.ObjectID `json:"author_id" bson:"author_id"`
 	LikesCount int                `json:"likes_count" bson:"likes_count"`
-	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
-	UpdatedAt  time.Time          `json:"updated_at" bson:"updated_at"`
+	// CommentsCount counts the comments and replies that were not deleted.
+	CommentsCount int        `json:"comments_count" bson:"comments_count"`
+	CreatedAt     time.Time  `json:"created_at" bson:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" bson:"updated_at"`
+	EditedAt      *time.Time `json:"edited_at" bson:"edited_at,omitempty"`
+
+	// LikedBy lists the users who liked the post, oldest like first. It is
+	// only exposed page by page, see database.GetPostLikers.
+	LikedBy []primitive.ObjectID `json:"-" bson:"liked_by,omitempty"`
+
+	// AuthorDeactivated hides the post while its author is deactivated and
+	// AuthorPrivate limits it to the author's approved followers.
+	AuthorDeactivated bool `json:"-" bson:"author_deactivated,omitempty"`
+	AuthorPrivate     bool `json:"-" bson:"author_private,omitempty"`
 }
 
+// Parse reads a new post or an edit of p. Only the content is taken from the
+// request; an edit keeps the ID, author, likes and creation time of p.
 func (p *Post) Parse(c *fiber.Ctx, authorID primitive.ObjectID, isUpdate bool) error {
-	if err := c.BodyParser(p); err != nil {
+	var input struct {
+		Content string `json:"content"`
+	}
+	if err := c.BodyParser(&input); err != nil {
 		return fmt.Errorf("invalid JSON: %w", err)
 	}
 
-	if p.Content == "" {
+	if input.Content == "" {
 		return fmt.Errorf("content is required")
 	}
+	p.Content = input.Content
 
 	if !isUpdate {
 		p.ID = primitive.NewObjectID()
+		p.AuthorID = authorID
 		p.CreatedAt = time.Now()
 		p.LikesCount = 0
-
 	}
-	p.AuthorID = authorID
 
 	p.UpdatedAt = time.Now()
 
 	return nil
 }
 
+// PostRevision is an earlier version of an edited post. CreatedAt is when
+// that version was written and ReplacedAt when it was edited.
+type PostRevision struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	PostID     primitive.ObjectID `bson:"post_id" json:"post_id"`
+	AuthorID   primitive.ObjectID `bson:"author_id" json:"-"`
+	Content    string             `bson:"content" json:"content"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	ReplacedAt time.Time          `bson:"replaced_at" json:"replaced_at"`
+}
+
+const MaxCommentLength = 1000
+
+// Comment is a comment on a post or, if ParentID is set, a reply to another
+// comment of the same post. Depth is 0 for comments on the post itself.
+//
+// A comment that has replies is kept when it is deleted so the thread stays
+// intact; only Deleted remains and its content and author are removed.
+type Comment struct {
+	ID           primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	PostID       primitive.ObjectID   `bson:"post_id" json:"post_id"`
+	ParentID     *primitive.ObjectID  `bson:"parent_id,omitempty" json:"parent_id"`
+	AuthorID     primitive.ObjectID   `bson:"author_id" json:"author_id"`
+	Content      string               `bson:"content" json:"content"`
+	Depth        int                  `bson:"depth" json:"depth"`
+	RepliesCount int                  `bson:"replies_count" json:"replies_count"`
+	LikesCount   int                  `bson:"likes_count" json:"likes_count"`
+	LikedBy      []primitive.ObjectID `bson:"liked_by,omitempty" json:"-"`
+	CreatedAt    time.Time            `bson:"created_at" json:"created_at"`
+	Deleted      bool                 `bson:"deleted,omitempty" json:"deleted"`
+
+	AuthorDeactivated bool `bson:"author_deactivated,omitempty" json:"-"`
+}
+
+// Parse reads a new comment by authorID on postID. A reply names the
+// comment it answers in parent_id.
+func (cm *Comment) Parse(c *fiber.Ctx, postID, authorID primitive.ObjectID) error {
+	var input struct {
+		Content  string `json:"content"`
+		ParentID string `json:"parent_id"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	content := strings.TrimSpace(input.Content)
+	if content == "" {
+		return fmt.Errorf("content is required")
+	}
+	if utf8.RuneCountInString(content) > MaxCommentLength {
+		return fmt.Errorf("content must be at most %d characters", MaxCommentLength)
+	}
+
+	if input.ParentID != "" {
+		parentID, err := primitive.ObjectIDFromHex(input.ParentID)
+		if err != nil {
+			return fmt.Errorf("invalid parent_id")
+		}
+		cm.ParentID = &parentID
+	}
+
+	cm.ID = primitive.NewObjectID()
+	cm.PostID = postID
+	cm.AuthorID = authorID
+	cm.Content = content
+	cm.CreatedAt = time.Now()
+
+	return nil
+}
+
 type NotificationType string
 
 const (
-	NotificationTypeLike NotificationType = "like"
+	NotificationTypeLike          NotificationType = "like"
+	NotificationTypeFollow        NotificationType = "follow"
+	NotificationTypeFollowRequest NotificationType = "follow_request"
+	NotificationTypeComment       NotificationType = "comment"
+	NotificationTypeReply         NotificationType = "reply"
+	NotificationTypeCommentLike   NotificationType = "comment_like"
 	// Add other notification types here as needed
 )
 
+// Notification is delivered to the user in LikedBy, which despite its name
+// holds the recipient for every notification type. ActorID is the user that
+// caused it.
 type Notification struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
 	Type      NotificationType   `bson:"type" json:"type"`
-	PostID    primitive.ObjectID `bson:"post_id" json:"post_id"`
+	PostID    primitive.ObjectID `bson:"post_id,omitempty" json:"post_id"`
+	CommentID primitive.ObjectID `bson:"comment_id,omitempty" json:"comment_id"`
 	LikedBy   primitive.ObjectID `bson:"liked_by" json:"liked_by"`
+	ActorID   primitive.ObjectID `bson:"actor_id,omitempty" json:"actor_id"`
 	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
 }
 
-func NewNotificationLike(postID, likedBy primitive.ObjectID) *Notification {
+func NewNotificationLike(postID, likedBy, actorID primitive.ObjectID) *Notification {
 	return &Notification{
 		Type:      NotificationTypeLike,
 		PostID:    postID,
 		LikedBy:   likedBy,
+		ActorID:   actorID,
+		CreatedAt: time.Now(),
+	}
+}
+
+// NewNotificationComment tells the recipient about a comment on their post,
+// a reply to their comment or a like of their comment, depending on
+// notificationType.
+func NewNotificationComment(notificationType NotificationType, comment *Comment, recipientID, actorID primitive.ObjectID) *Notification {
+	return &Notification{
+		Type:      notificationType,
+		PostID:    comment.PostID,
+		CommentID: comment.ID,
+		LikedBy:   recipientID,
+		ActorID:   actorID,
+		CreatedAt: time.Now(),
+	}
+}
+
+func NewNotificationFollow(followeeID, followerID primitive.ObjectID) *Notification {
+	return &Notification{
+		Type:      NotificationTypeFollow,
+		LikedBy:   followeeID,
+		ActorID:   followerID,
+		CreatedAt: time.Now(),
+	}
+}
+
+func NewNotificationFollowRequest(followeeID, followerID primitive.ObjectID) *Notification {
+	return &Notification{
+		Type:      NotificationTypeFollowRequest,
+		LikedBy:   followeeID,
+		ActorID:   followerID,
 		CreatedAt: time.Now(),
 	}
 }
+
+type FollowStatus string
+
+const (
+	// FollowPending is a request to follow a private account that was not
+	// approved yet.
+	FollowPending  FollowStatus = "pending"
+	FollowAccepted FollowStatus = "accepted"
+)
+
+// Follow records that FollowerID follows FolloweeID. Follows created before
+// follow requests existed have no status and count as accepted.
+type Follow struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	FollowerID primitive.ObjectID `bson:"follower_id" json:"follower_id"`
+	FolloweeID primitive.ObjectID `bson:"followee_id" json:"followee_id"`
+	Status     FollowStatus       `bson:"status,omitempty" json:"status"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+}
+
+func (f *Follow) Pending() bool {
+	return f.Status == FollowPending
+}
+
+type RestrictionKind string
+
+const (
+	// RestrictionBlock hides both users from each other and stops any
+	// interaction between them.
+	RestrictionBlock RestrictionKind = "block"
+	// RestrictionMute only hides the target from the user who muted them.
+	RestrictionMute RestrictionKind = "mute"
+)
+
+// Restriction is a block or mute of TargetID by UserID.
+type Restriction struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"-"`
+	TargetID  primitive.ObjectID `bson:"target_id" json:"target_id"`
+	Kind      RestrictionKind    `bson:"kind" json:"kind"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+type DeletionJobStatus string
+
+const (
+	DeletionJobPending   DeletionJobStatus = "pending"
+	DeletionJobRunning   DeletionJobStatus = "running"
+	DeletionJobCompleted DeletionJobStatus = "completed"
+	DeletionJobFailed    DeletionJobStatus = "failed"
+)
+
+// DeletionJob tracks the removal of an account and everything that depends
+// on it. CompletedSteps lets a job resume where it stopped after a crash or
+// failure.
+type DeletionJob struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty"`
+	UserID         primitive.ObjectID `bson:"user_id"`
+	RequestedBy    primitive.ObjectID `bson:"requested_by"`
+	Status         DeletionJobStatus  `bson:"status"`
+	Step           string             `bson:"step,omitempty"`
+	CompletedSteps []string           `bson:"completed_steps"`
+	TotalSteps     int                `bson:"total_steps"`
+	Attempts       int                `bson:"attempts"`
+	Error          string             `bson:"error,omitempty"`
+	LeaseUntil     time.Time          `bson:"lease_until"`
+	CreatedAt      time.Time          `bson:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at"`
+	CompletedAt    *time.Time         `bson:"completed_at,omitempty"`
+}
+
+func (j *DeletionJob) HasCompleted(step string) bool {
+	for _, s := range j.CompletedSteps {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
+func (j *DeletionJob) ToResponse() map[string]interface{} {
+	return map[string]interface{}{
+		"id":              j.ID,
+		"user_id":         j.UserID,
+		"status":          j.Status,
+		"step":            j.Step,
+		"completed_steps": len(j.CompletedSteps),
+		"total_steps":     j.TotalSteps,
+		"error":           j.Error,
+		"created_at":      j.CreatedAt,
+		"updated_at":      j.UpdatedAt,
+		"completed_at":    j.CompletedAt,
+	}
+}
+
+type DataExportStatus string
+
+const (
+	DataExportPending   DataExportStatus = "pending"
+	DataExportRunning   DataExportStatus = "running"
+	DataExportCompleted DataExportStatus = "completed"
+	DataExportFailed    DataExportStatus = "failed"
+)
+
+// DataExport is a ZIP archive of everything stored about a user, built in
+// the background and kept in the blob store under BlobKey until ExpiresAt.
+type DataExport struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	UserID      primitive.ObjectID `bson:"user_id"`
+	Status      DataExportStatus   `bson:"status"`
+	BlobKey     string             `bson:"blob_key,omitempty"`
+	Size        int64              `bson:"size"`
+	Attempts    int                `bson:"attempts"`
+	Error       string             `bson:"error,omitempty"`
+	LeaseUntil  time.Time          `bson:"lease_until"`
+	CreatedAt   time.Time          `bson:"created_at"`
+	CompletedAt *time.Time         `bson:"completed_at,omitempty"`
+	ExpiresAt   *time.Time         `bson:"expires_at,omitempty"`
+}
+
+// Active reports whether the export is still being built.
+func (e *DataExport) Active() bool {
+	return e.Status == DataExportPending || e.Status == DataExportRunning
+}
+
+func (e *DataExport) ToResponse() map[string]interface{} {
+	return map[string]interface{}{
+		"id":           e.ID,
+		"status":       e.Status,
+		"size":         e.Size,
+		"error":        e.Error,
+		"created_at":   e.CreatedAt,
+		"completed_at": e.CompletedAt,
+		"expires_at":   e.ExpiresAt,
+	}
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateUsername(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"ghennadi", true},
+		{"Ghen_2024", true},
+		{"first.last", true},
+		{"a.b.c", true},
+		{"abc", true},
+		{strings.Repeat("a", MaxUsernameLength), true},
+
+		{"ab", false},
+		{strings.Repeat("a", MaxUsernameLength+1), false},
+		{"", false},
+		{".ghen", false},
+		{"ghen.", false},
+		{"gh..en", false},
+		{"ghen-nadi", false},
+		{"ghen nadi", false},
+		{"ghén", false},
+		{"me", false},
+		{"admin", false},
+		{"Admin", false},
+		{"ROOT", false},
+		{"glamapp", false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateUsername(tt.name)
+		if tt.ok && err != nil {
+			t.Errorf("ValidateUsername(%q) = %v, want nil", tt.name, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("ValidateUsername(%q) accepted the name", tt.name)
+		}
+	}
+}
+
+func TestSetSearchKeys(t *testing.T) {
+	user := &User{Name: "GhenNadi", DisplayName: "  Ghennadi   Von Glam "}
+	user.SetSearchKeys()
+
+	if user.NameLower != "ghennadi" {
+		t.Errorf("NameLower = %q", user.NameLower)
+	}
+	want := []string{"ghennadi von glam", "von glam", "glam"}
+	if strings.Join(user.DisplayNameWords, "|") != strings.Join(want, "|") {
+		t.Errorf("DisplayNameWords = %q, want %q", user.DisplayNameWords, want)
+	}
+
+	user.DisplayName = ""
+	user.SetSearchKeys()
+	if user.DisplayNameWords != nil {
+		t.Errorf("DisplayNameWords = %q for an empty display name", user.DisplayNameWords)
+	}
+}
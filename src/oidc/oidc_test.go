@@ -0,0 +1,100 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"glamapp/src/oidc"
+	"glamapp/src/oidc/oidctest"
+)
+
+func newProvider(t *testing.T) (*oidc.Provider, *oidctest.Issuer) {
+	t.Helper()
+	issuer := oidctest.NewIssuer(t, "glamapp", "secret")
+	provider := oidc.NewProvider(oidc.Config{
+		Name:         "mock",
+		Issuer:       issuer.URL,
+		ClientID:     issuer.ClientID,
+		ClientSecret: issuer.ClientSecret,
+		RedirectURL:  "http://127.0.0.1:3000/auth/oidc/mock/callback",
+	})
+	return provider, issuer
+}
+
+func TestExchange(t *testing.T) {
+	provider, issuer := newProvider(t)
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	code, state := issuer.Authorize(t, authURL, "subject-1", map[string]interface{}{
+		"email":              "ghennadi@example.com",
+		"email_verified":     true,
+		"preferred_username": "ghennadi",
+	})
+	if state != "state" {
+		t.Fatalf("state = %q, want %q", state, "state")
+	}
+
+	claims, err := provider.Exchange(ctx, code, "verifier", "nonce")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if claims.Subject != "subject-1" || claims.Email != "ghennadi@example.com" || !claims.EmailVerified || claims.PreferredUsername != "ghennadi" {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	if _, err := provider.Exchange(ctx, code, "verifier", "nonce"); err == nil {
+		t.Fatal("Exchange accepted a code twice")
+	}
+}
+
+func TestExchangeRequiresPKCEVerifier(t *testing.T) {
+	provider, issuer := newProvider(t)
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	code, _ := issuer.Authorize(t, authURL, "subject-1", nil)
+
+	if _, err := provider.Exchange(ctx, code, "another-verifier", "nonce"); err == nil {
+		t.Fatal("Exchange accepted a wrong code verifier")
+	}
+}
+
+func TestExchangeRejectsInvalidIDTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+	}{
+		{"nonce", map[string]interface{}{"nonce": "replayed"}},
+		{"audience", map[string]interface{}{"aud": "another-client"}},
+		{"issuer", map[string]interface{}{"iss": "https://issuer.example"}},
+		{"expired", map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()}},
+		{"subject", map[string]interface{}{"sub": nil}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, issuer := newProvider(t)
+			ctx := context.Background()
+
+			authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", "verifier")
+			if err != nil {
+				t.Fatalf("AuthCodeURL: %v", err)
+			}
+			code, _ := issuer.Authorize(t, authURL, "subject-1", tt.claims)
+
+			_, err = provider.Exchange(ctx, code, "verifier", "nonce")
+			if !errors.Is(err, oidc.ErrInvalidIDToken) {
+				t.Fatalf("Exchange error = %v, want ErrInvalidIDToken", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package passwords
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// verifyBcrypt checks hashes created before Argon2id became the default.
+// New bcrypt hashes are never created.
+func verifyBcrypt(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
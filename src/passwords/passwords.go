@@ -0,0 +1,63 @@
+package passwords
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MaxLength caps password length. Longer inputs only make hashing expensive,
+// and bcrypt would silently ignore everything past 72 bytes.
+const MaxLength = 128
+
+var (
+	ErrTooLong       = fmt.Errorf("password must be at most %d bytes", MaxLength)
+	ErrEmptyPassword = errors.New("password must not be empty")
+	ErrMalformedHash = errors.New("malformed password hash")
+)
+
+var defaultHasher Hasher = NewArgon2id(DefaultArgon2Params)
+
+// Hasher turns passwords into encoded PHC strings that carry their own
+// algorithm and parameters, so older hashes keep verifying after a change.
+type Hasher interface {
+	Hash(password string) (string, error)
+	Verify(password, encoded string) (bool, error)
+	// NeedsRehash reports whether encoded was produced by another algorithm
+	// or with other parameters than the hasher would use now.
+	NeedsRehash(encoded string) bool
+}
+
+// SetDefault replaces the hasher used by Hash, Verify and NeedsRehash. It is
+// meant to be called once on startup.
+func SetDefault(h Hasher) {
+	defaultHasher = h
+}
+
+func Hash(password string) (string, error) {
+	if password == "" {
+		return "", ErrEmptyPassword
+	}
+	if len(password) > MaxLength {
+		return "", ErrTooLong
+	}
+	return defaultHasher.Hash(password)
+}
+
+// Verify checks password against a hash made by the default hasher or a
+// legacy bcrypt hash. MaxLength is not enforced here, legacy bcrypt users
+// may have longer passwords.
+func Verify(password, encoded string) (bool, error) {
+	if isBcrypt(encoded) {
+		return verifyBcrypt(password, encoded)
+	}
+	return defaultHasher.Verify(password, encoded)
+}
+
+func NeedsRehash(encoded string) bool {
+	return isBcrypt(encoded) || defaultHasher.NeedsRehash(encoded)
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
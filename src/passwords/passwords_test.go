@@ -0,0 +1,158 @@
+package passwords
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testParams keep the tests fast, they are far below what production uses.
+var testParams = Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1}
+
+func useTestHasher(t *testing.T) {
+	t.Helper()
+	previous := defaultHasher
+	SetDefault(NewArgon2id(testParams))
+	t.Cleanup(func() { SetDefault(previous) })
+}
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	hasher := NewArgon2id(testParams)
+
+	encoded, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("unexpected encoding %q", encoded)
+	}
+
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		t.Fatalf("decodeArgon2id: %v", err)
+	}
+	if params != testParams || len(salt) != argon2SaltLength || len(key) != argon2KeyLength {
+		t.Fatalf("decoded %+v, %d byte salt, %d byte key", params, len(salt), len(key))
+	}
+
+	if ok, err := hasher.Verify("correct horse", encoded); err != nil || !ok {
+		t.Errorf("Verify(correct) = %v, %v", ok, err)
+	}
+	if ok, err := hasher.Verify("wrong horse", encoded); err != nil || ok {
+		t.Errorf("Verify(wrong) = %v, %v", ok, err)
+	}
+
+	other, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if other == encoded {
+		t.Error("two hashes of the same password share a salt")
+	}
+}
+
+func TestArgon2idMalformedHash(t *testing.T) {
+	hasher := NewArgon2id(testParams)
+	valid, err := hasher.Hash("secret")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	parts := strings.Split(valid, "$")
+
+	tests := map[string]string{
+		"empty":          "",
+		"other algo":     strings.Replace(valid, "argon2id", "argon2i", 1),
+		"other version":  strings.Replace(valid, "v=19", "v=16", 1),
+		"bad params":     strings.Replace(valid, "m=64,t=1,p=1", "m=x,t=1,p=1", 1),
+		"bad salt":       strings.Join([]string{"", parts[1], parts[2], parts[3], "!!", parts[5]}, "$"),
+		"empty key":      strings.Join([]string{"", parts[1], parts[2], parts[3], parts[4], ""}, "$"),
+		"missing fields": strings.Join(parts[:5], "$"),
+	}
+
+	for name, encoded := range tests {
+		t.Run(name, func(t *testing.T) {
+			ok, err := hasher.Verify("secret", encoded)
+			if ok || !errors.Is(err, ErrMalformedHash) {
+				t.Errorf("Verify = %v, %v, want false, ErrMalformedHash", ok, err)
+			}
+			if !hasher.NeedsRehash(encoded) {
+				t.Error("NeedsRehash = false for a malformed hash")
+			}
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	useTestHasher(t)
+
+	current, err := Hash("secret")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if NeedsRehash(current) {
+		t.Error("NeedsRehash = true for a hash with the current parameters")
+	}
+
+	changes := []Argon2Params{
+		{Memory: 128, Iterations: 1, Parallelism: 1},
+		{Memory: 64, Iterations: 2, Parallelism: 1},
+		{Memory: 64, Iterations: 1, Parallelism: 2},
+	}
+	for _, params := range changes {
+		old, err := NewArgon2id(params).Hash("secret")
+		if err != nil {
+			t.Fatalf("Hash: %v", err)
+		}
+		if !NeedsRehash(old) {
+			t.Errorf("NeedsRehash = false for %+v", params)
+		}
+		// Older parameters keep verifying until the hash is replaced.
+		if ok, err := Verify("secret", old); err != nil || !ok {
+			t.Errorf("Verify with %+v = %v, %v", params, ok, err)
+		}
+	}
+}
+
+func TestVerifyLegacyBcrypt(t *testing.T) {
+	useTestHasher(t)
+
+	// Legacy users could pick passwords longer than MaxLength, of which
+	// bcrypt only hashed the first 72 bytes.
+	long := strings.Repeat("p", MaxLength+10)
+	for _, password := range []string{"legacy secret", long} {
+		hashed := password
+		if len(hashed) > 72 {
+			hashed = hashed[:72]
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(hashed), bcrypt.MinCost)
+		if err != nil {
+			t.Fatalf("GenerateFromPassword: %v", err)
+		}
+
+		if ok, err := Verify(password, string(hash)); err != nil || !ok {
+			t.Errorf("Verify(%d bytes) = %v, %v", len(password), ok, err)
+		}
+		if ok, err := Verify("wrong", string(hash)); err != nil || ok {
+			t.Errorf("Verify(wrong) = %v, %v", ok, err)
+		}
+		if !NeedsRehash(string(hash)) {
+			t.Error("NeedsRehash = false for a bcrypt hash")
+		}
+	}
+}
+
+func TestHashLimits(t *testing.T) {
+	useTestHasher(t)
+
+	if _, err := Hash(""); !errors.Is(err, ErrEmptyPassword) {
+		t.Errorf("Hash(empty) error = %v, want ErrEmptyPassword", err)
+	}
+	if _, err := Hash(strings.Repeat("p", MaxLength+1)); !errors.Is(err, ErrTooLong) {
+		t.Errorf("Hash(too long) error = %v, want ErrTooLong", err)
+	}
+	if _, err := Hash(strings.Repeat("p", MaxLength)); err != nil {
+		t.Errorf("Hash(MaxLength) error = %v", err)
+	}
+}
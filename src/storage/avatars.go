@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"glamapp/src/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PutAvatar stores every size of a processed avatar under a fresh key prefix
+// and returns the reference to save on the user.
+func PutAvatar(ctx context.Context, store BlobStore, userID primitive.ObjectID, contentType string, sizes map[int][]byte) (*models.AvatarRef, error) {
+	version := make([]byte, 8)
+	if _, err := rand.Read(version); err != nil {
+		return nil, fmt.Errorf("failed to generate avatar key: %w", err)
+	}
+
+	ref := &models.AvatarRef{
+		ContentType: contentType,
+		Keys:        make(map[string]string, len(sizes)),
+		UpdatedAt:   time.Now(),
+	}
+	for size, data := range sizes {
+		key := fmt.Sprintf("avatars/%s/%s-%d", userID.Hex(), hex.EncodeToString(version), size)
+		if err := store.Put(ctx, key, data); err != nil {
+			DeleteAvatar(ctx, store, ref)
+			return nil, err
+		}
+		ref.Keys[strconv.Itoa(size)] = key
+	}
+	return ref, nil
+}
+
+// DeleteAvatar removes every blob of ref. A nil ref is a no-op.
+func DeleteAvatar(ctx context.Context, store BlobStore, ref *models.AvatarRef) error {
+	if ref == nil {
+		return nil
+	}
+	for _, key := range ref.Keys {
+		if err := store.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const gridFSBucketName = "blobs"
+
+// GridFSStore uses the key as the GridFS file ID.
+type GridFSStore struct {
+	bucket *gridfs.Bucket
+}
+
+func NewGridFSStore(db *mongo.Database) (*GridFSStore, error) {
+	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName(gridFSBucketName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GridFS bucket: %w", err)
+	}
+	return &GridFSStore{bucket: bucket}, nil
+}
+
+func (s *GridFSStore) Put(ctx context.Context, key string, data []byte) error {
+	return s.PutStream(ctx, key, bytes.NewReader(data))
+}
+
+func (s *GridFSStore) PutStream(ctx context.Context, key string, r io.Reader) error {
+	if err := s.bucket.UploadFromStreamWithID(key, key, r); err != nil {
+		return fmt.Errorf("failed to upload blob %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *GridFSStore) Get(ctx context.Context, key string) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := s.bucket.DownloadToStream(key, &buf); err != nil {
+		if errors.Is(err, gridfs.ErrFileNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to download blob %s: %w", key, err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *GridFSStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	stream, err := s.bucket.OpenDownloadStream(key)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob %s: %w", key, err)
+	}
+	return stream, nil
+}
+
+func (s *GridFSStore) Delete(ctx context.Context, key string) error {
+	if err := s.bucket.DeleteContext(ctx, key); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+		return fmt.Errorf("failed to delete blob %s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+// Package storage keeps binary media such as avatars outside of the user
+// documents. The backend is chosen through the blob_store config option.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore stores blobs under slash separated keys. Keys are never reused:
+// new content gets a new key and the old blob is deleted. PutStream and Open
+// are for blobs too large to hold in memory, such as data exports.
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	PutStream(ctx context.Context, key string, r io.Reader) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// New returns the store configured by kind: "gridfs" keeps blobs in a GridFS
+// bucket of db and "filesystem" stores them as files below dir.
+func New(kind, dir string, db *mongo.Database) (BlobStore, error) {
+	switch kind {
+	case "gridfs":
+		return NewGridFSStore(db)
+	case "filesystem":
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create blob directory: %w", err)
+		}
+		return &FileStore{Dir: dir}, nil
+	default:
+		return nil, fmt.Errorf("unknown blob store %q", kind)
+	}
+}
@@ -0,0 +1,56 @@
+package tokens
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	// TypeAccess tokens authenticate API requests.
+	TypeAccess = "access"
+	// TypeMFA tokens only prove the password step of a two-factor login.
+	TypeMFA = "mfa"
+)
+
+var ErrWrongTokenType = errors.New("wrong token type")
+
+type Claims struct {
+	UserID    string `json:"user_id"`
+	SessionID string `json:"sid,omitempty"`
+	Type      string `json:"typ"`
+	jwt.RegisteredClaims
+}
+
+// Issue signs a token of the given type for a user.
+func (ks *KeySet) Issue(typ, userID, sessionID string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := Claims{
+		UserID:    userID,
+		SessionID: sessionID,
+		Type:      typ,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    ks.Issuer,
+			Subject:   userID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	return ks.Sign(claims)
+}
+
+// Verify parses a token issued by Issue and checks its type and issuer.
+func (ks *KeySet) Verify(token, typ string) (*Claims, error) {
+	claims := &Claims{}
+	if err := ks.Parse(token, claims); err != nil {
+		return nil, err
+	}
+	if !claims.VerifyIssuer(ks.Issuer, true) {
+		return nil, jwt.ErrTokenInvalidIssuer
+	}
+	if claims.Type != typ {
+		return nil, ErrWrongTokenType
+	}
+	return claims, nil
+}
@@ -0,0 +1,215 @@
+package tokens
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const testIssuer = "glamapp"
+
+type testKeys struct {
+	set *KeySet
+	// current signs new tokens, retired only has its public half on disk and
+	// removed was rotated out of the set altogether.
+	current, retired, removed *Key
+	// retiredPEM is the public key file of retired.
+	retiredPEM []byte
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	t.Helper()
+	dir := t.TempDir()
+
+	_, currentPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retiredPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, removedPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	currentDER, err := x509.MarshalPKCS8PrivateKey(currentPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retiredDER, err := x509.MarshalPKIXPublicKey(&retiredPrivate.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retiredPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: retiredDER})
+	writePEM := func(name string, data []byte) {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writePEM("2024-07.pem", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: currentDER}))
+	writePEM("2024-01.pem", retiredPEM)
+
+	set, err := LoadKeySet(dir, "2024-07", testIssuer)
+	if err != nil {
+		t.Fatalf("LoadKeySet: %v", err)
+	}
+
+	return &testKeys{
+		set:        set,
+		current:    &Key{ID: "2024-07", Method: jwt.SigningMethodEdDSA, Private: currentPrivate},
+		retired:    &Key{ID: "2024-01", Method: jwt.SigningMethodRS256, Private: retiredPrivate},
+		removed:    &Key{ID: "2023-07", Method: jwt.SigningMethodEdDSA, Private: removedPrivate},
+		retiredPEM: retiredPEM,
+	}
+}
+
+func testClaims(typ string) Claims {
+	now := time.Now()
+	return Claims{
+		UserID: "user",
+		Type:   typ,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    testIssuer,
+			Subject:   "user",
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+	}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+	return signed
+}
+
+func TestKeySetIssueAndVerify(t *testing.T) {
+	keys := newTestKeys(t)
+
+	token, err := keys.set.Issue(TypeAccess, "user", "session", time.Minute)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	claims, err := keys.set.Verify(token, TypeAccess)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if claims.UserID != "user" || claims.SessionID != "session" {
+		t.Errorf("got claims %+v", claims)
+	}
+
+	if _, err := keys.set.Verify(token, TypeMFA); !errors.Is(err, ErrWrongTokenType) {
+		t.Errorf("Verify as mfa token error = %v, want ErrWrongTokenType", err)
+	}
+}
+
+func TestKeySetParse(t *testing.T) {
+	keys := newTestKeys(t)
+	claims := testClaims(TypeAccess)
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{
+			name:  "current key",
+			token: sign(t, keys.current.Method, keys.current.ID, keys.current.Private, claims),
+			ok:    true,
+		},
+		{
+			name:  "retired key kept for verification",
+			token: sign(t, keys.retired.Method, keys.retired.ID, keys.retired.Private, claims),
+			ok:    true,
+		},
+		{
+			name:  "rotated out key",
+			token: sign(t, keys.removed.Method, keys.removed.ID, keys.removed.Private, claims),
+		},
+		{
+			name:  "rotated out key under a known kid",
+			token: sign(t, keys.removed.Method, keys.current.ID, keys.removed.Private, claims),
+		},
+		{
+			name:  "unknown kid",
+			token: sign(t, keys.current.Method, "unknown", keys.current.Private, claims),
+		},
+		{
+			name:  "missing kid",
+			token: sign(t, keys.current.Method, "", keys.current.Private, claims),
+		},
+		{
+			name:  "alg none",
+			token: sign(t, jwt.SigningMethodNone, keys.current.ID, jwt.UnsafeAllowNoneSignatureType, claims),
+		},
+		{
+			name:  "HS256 with the public key as secret",
+			token: sign(t, jwt.SigningMethodHS256, keys.retired.ID, keys.retiredPEM, claims),
+		},
+		{
+			name:  "RS256 under an EdDSA kid",
+			token: sign(t, jwt.SigningMethodRS256, keys.current.ID, keys.retired.Private, claims),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := keys.set.Parse(tt.token, &Claims{})
+			if tt.ok && err != nil {
+				t.Errorf("Parse error = %v, want nil", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("Parse accepted the token")
+			}
+		})
+	}
+}
+
+func TestKeySetVerifyChecksClaims(t *testing.T) {
+	keys := newTestKeys(t)
+
+	expired := testClaims(TypeAccess)
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	otherIssuer := testClaims(TypeAccess)
+	otherIssuer.Issuer = "someone-else"
+
+	for name, claims := range map[string]Claims{"expired": expired, "other issuer": otherIssuer} {
+		token := sign(t, keys.current.Method, keys.current.ID, keys.current.Private, claims)
+		if _, err := keys.set.Verify(token, TypeAccess); err == nil {
+			t.Errorf("%s: Verify accepted the token", name)
+		}
+	}
+}
+
+func TestLoadKeySetRequiresPrivateSigningKey(t *testing.T) {
+	keys := newTestKeys(t)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "2024-01.pem"), keys.retiredPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadKeySet(dir, "2024-01", testIssuer); err == nil {
+		t.Error("LoadKeySet accepted a public key for signing")
+	}
+	if _, err := LoadKeySet(dir, "2024-07", testIssuer); err == nil {
+		t.Error("LoadKeySet accepted a missing signing key")
+	}
+}
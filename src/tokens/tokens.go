@@ -0,0 +1,41 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+const opaqueTokenBytes = 32
+
+// PersonalPrefix marks personal access tokens so they can be told apart
+// from JWTs without a database lookup, and found by secret scanners.
+const PersonalPrefix = "glp_"
+
+// Generate returns a random, URL-safe opaque token suitable for refresh tokens
+// and other secrets that are handed to clients and stored only as a hash.
+func Generate() (string, error) {
+	buf := make([]byte, opaqueTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Hash returns the hex encoded SHA-256 of an opaque token. Only hashes are
+// persisted so a database leak does not expose usable tokens.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GeneratePersonal returns a new personal access token.
+func GeneratePersonal() (string, error) {
+	token, err := Generate()
+	if err != nil {
+		return "", err
+	}
+	return PersonalPrefix + token, nil
+}
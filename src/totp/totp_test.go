@@ -0,0 +1,129 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of the RFC 6238 Appendix B test vectors.
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeRFC6238(t *testing.T) {
+	// The RFC lists 8 digit codes, the last Digits of them are ours.
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tt := range tests {
+		want := tt.code[len(tt.code)-Digits:]
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("Code(%d): %v", tt.unix, err)
+		}
+		if got != want {
+			t.Errorf("Code(%d) = %s, want %s", tt.unix, got, want)
+		}
+
+		step, ok := Validate(rfcSecret, want, time.Unix(tt.unix, 0))
+		if !ok || step != tt.unix/30 {
+			t.Errorf("Validate(%d) = %d, %v, want %d, true", tt.unix, step, ok, tt.unix/30)
+		}
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	issued := time.Unix(1111111111, 0)
+	code, err := Code(rfcSecret, issued)
+	if err != nil {
+		t.Fatalf("Code: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		offset time.Duration
+		ok     bool
+	}{
+		{"same step", 0, true},
+		{"one step later", Period, true},
+		{"one step earlier", -Period, true},
+		{"two steps later", 2 * Period, false},
+		{"two steps earlier", -2 * Period, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, code, issued.Add(tt.offset))
+			if ok != tt.ok {
+				t.Fatalf("Validate ok = %v, want %v", ok, tt.ok)
+			}
+			// The step is the one the code was issued in, so callers can
+			// reject it once it was used.
+			if ok && step != issued.Unix()/30 {
+				t.Errorf("Validate step = %d, want %d", step, issued.Unix()/30)
+			}
+		})
+	}
+}
+
+func TestValidateRejectsMalformedInput(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, err := Code(rfcSecret, now)
+	if err != nil {
+		t.Fatalf("Code: %v", err)
+	}
+
+	tests := []struct {
+		name, secret, code string
+		ok                 bool
+	}{
+		{"spaced code", rfcSecret, code[:3] + " " + code[3:], true},
+		{"lowercase secret", strings.ToLower(rfcSecret), code, true},
+		{"short code", rfcSecret, code[:Digits-1], false},
+		{"long code", rfcSecret, code + "0", false},
+		{"invalid secret", "not base32!", code, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := Validate(tt.secret, tt.code, now); ok != tt.ok {
+				t.Errorf("Validate ok = %v, want %v", ok, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes: %v", err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("got %d codes, want 10", len(codes))
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 9 || code[4] != '-' {
+			t.Errorf("code %q is not formatted as xxxx-xxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("code %q generated twice", code)
+		}
+		seen[code] = true
+
+		typed := " " + strings.ToUpper(strings.ReplaceAll(code, "-", " ")) + " "
+		if got := NormalizeRecoveryCode(typed); got != code {
+			t.Errorf("NormalizeRecoveryCode(%q) = %q, want %q", typed, got, code)
+		}
+	}
+}